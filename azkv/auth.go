@@ -0,0 +1,221 @@
+package azkv
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/keyvault/keyvault"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+	"github.com/Azure/go-autorest/autorest/azure/cli"
+	"github.com/mmlt/tool-tmplt/retry"
+)
+
+// Authentication methods
+// https://docs.microsoft.com/en-us/azure/go/azure-sdk-go-authorization
+
+// AuthMethods are the supported values of Auth.Method.
+var AuthMethods = []string{"environment", "cli", "msi", "certificate", "file"}
+
+// Auth selects how to authenticate with Azure Active Directory.
+type Auth struct {
+	// Method is one of AuthMethods, empty means "environment".
+	//	environment - client secret from AZURE_TENANT_ID, AZURE_CLIENT_ID, AZURE_CLIENT_SECRET
+	//	cli - the login of the Azure CLI (az login)
+	//	msi - managed identity, AZURE_CLIENT_ID selects a user assigned identity
+	//	certificate - client certificate from AZURE_TENANT_ID, AZURE_CLIENT_ID, AZURE_CERTIFICATE_PATH and
+	//		(optionally) AZURE_CERTIFICATE_PASSWORD
+	//	file - the file at AZURE_AUTH_LOCATION as created by 'az ad sp create-for-rbac --sdk-auth'
+	Method string
+	// Env are the environment variables.
+	Env map[string]string
+
+	// Cloud is the Azure cloud, the zero value means the public cloud.
+	Cloud azure.Environment
+	// AADEndpoint when not empty overrides the Active Directory endpoint of the cloud.
+	AADEndpoint string
+	// Resource when not empty overrides the Key Vault resource of the cloud.
+	Resource string
+	// MSIEndpoint is the managed identity token endpoint, empty means the endpoint of the VM or App Service.
+	MSIEndpoint string
+}
+
+// Validate checks if the environment variables required by the method are set.
+func (a Auth) Validate() error {
+	var required []string
+	switch a.method() {
+	case "environment":
+		required = []string{"AZURE_TENANT_ID", "AZURE_CLIENT_ID", "AZURE_CLIENT_SECRET"}
+	case "certificate":
+		required = []string{"AZURE_TENANT_ID", "AZURE_CLIENT_ID", "AZURE_CERTIFICATE_PATH"}
+	case "file":
+		required = []string{"AZURE_AUTH_LOCATION"}
+	case "cli", "msi":
+	default:
+		return fmt.Errorf("unknown authentication method %s (expected one of %s)",
+			a.Method, strings.Join(AuthMethods, ", "))
+	}
+	for _, v := range required {
+		if a.Env[v] == "" {
+			return fmt.Errorf("authentication method %s requires environment variable %s to be set", a.method(), v)
+		}
+	}
+	return nil
+}
+
+// Login returns a Key Vault client that authenticates with method 'a'.
+// A token is requested before returning so wrong credentials are reported by Login.
+// Key Vault calls and token requests are retried according to 'policy'.
+func Login(a Auth, policy retry.Policy) (*keyvault.BaseClient, error) {
+	if err := a.Validate(); err != nil {
+		return nil, err
+	}
+
+	authorizer, err := a.authorizer(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	basicClient := keyvault.New()
+	basicClient.Authorizer = authorizer
+	// retries are done by the transport, the SDK retries 429 responses without limit.
+	basicClient.RetryAttempts = 0
+	basicClient.RetryDuration = 0
+	basicClient.Sender = &http.Client{
+		Transport: &retry.Transport{Policy: policy, Codes: retry.AzureCodes, Name: "azure key vault"},
+	}
+
+	return &basicClient, nil
+}
+
+// Authorizer returns an authorizer with a fresh token.
+// Token requests (also the refreshes during the expansion) are retried according to 'policy'.
+func (a Auth) authorizer(policy retry.Policy) (autorest.Authorizer, error) {
+	var spt *adal.ServicePrincipalToken
+	var err error
+	switch a.method() {
+	case "environment":
+		c := auth.NewClientCredentialsConfig(a.Env["AZURE_CLIENT_ID"], a.Env["AZURE_CLIENT_SECRET"], a.Env["AZURE_TENANT_ID"])
+		c.AADEndpoint, c.Resource = a.aadEndpoint(), a.resource()
+		spt, err = c.ServicePrincipalToken()
+	case "certificate":
+		c := auth.NewClientCertificateConfig(a.Env["AZURE_CERTIFICATE_PATH"], a.Env["AZURE_CERTIFICATE_PASSWORD"],
+			a.Env["AZURE_CLIENT_ID"], a.Env["AZURE_TENANT_ID"])
+		c.AADEndpoint, c.Resource = a.aadEndpoint(), a.resource()
+		spt, err = c.ServicePrincipalToken()
+	case "file":
+		spt, err = a.fileToken()
+	case "msi":
+		endpoint := a.MSIEndpoint
+		if endpoint == "" {
+			endpoint, err = adal.GetMSIEndpoint()
+			if err != nil {
+				return nil, err
+			}
+		}
+		if id := a.Env["AZURE_CLIENT_ID"]; id != "" {
+			spt, err = adal.NewServicePrincipalTokenFromMSIWithUserAssignedID(endpoint, a.resource(), id)
+		} else {
+			spt, err = adal.NewServicePrincipalTokenFromMSI(endpoint, a.resource())
+		}
+	case "cli":
+		// the az cli refreshes its own tokens, the token is used as is.
+		token, err := cli.GetTokenFromCLI(a.resource())
+		if err != nil {
+			return nil, fmt.Errorf("azure cli: %v", err)
+		}
+		adalToken, err := token.ToADALToken()
+		if err != nil {
+			return nil, fmt.Errorf("azure cli: %v", err)
+		}
+		return autorest.NewBearerAuthorizer(&adalToken), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	spt.SetSender(&http.Client{
+		// adal doesn't set GetBody on token requests.
+		Transport: &retry.Transport{Policy: policy, Codes: retry.AzureCodes, Name: "azure ad", ReplayBody: true},
+	})
+	if err := spt.EnsureFresh(); err != nil {
+		return nil, err
+	}
+	return autorest.NewBearerAuthorizer(spt), nil
+}
+
+// FileToken returns a token for the client secret or client certificate in the AZURE_AUTH_LOCATION file.
+func (a Auth) fileToken() (*adal.ServicePrincipalToken, error) {
+	location := a.Env["AZURE_AUTH_LOCATION"]
+	b, err := ioutil.ReadFile(location)
+	if err != nil {
+		return nil, err
+	}
+	var f struct {
+		ClientID                   string `json:"clientId"`
+		ClientSecret               string `json:"clientSecret"`
+		ClientCertificate          string `json:"clientCertificate"`
+		ClientCertificatePassword  string `json:"clientCertificatePassword"`
+		TenantID                   string `json:"tenantId"`
+		ActiveDirectoryEndpointURL string `json:"activeDirectoryEndpointUrl"`
+	}
+	err = json.Unmarshal(bytes.TrimPrefix(b, []byte("\xef\xbb\xbf")), &f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", location, err)
+	}
+
+	aad := a.aadEndpoint()
+	if a.AADEndpoint == "" && f.ActiveDirectoryEndpointURL != "" {
+		aad = f.ActiveDirectoryEndpointURL
+	}
+	switch {
+	case f.ClientSecret != "":
+		c := auth.NewClientCredentialsConfig(f.ClientID, f.ClientSecret, f.TenantID)
+		c.AADEndpoint, c.Resource = aad, a.resource()
+		return c.ServicePrincipalToken()
+	case f.ClientCertificate != "":
+		c := auth.NewClientCertificateConfig(f.ClientCertificate, f.ClientCertificatePassword, f.ClientID, f.TenantID)
+		c.AADEndpoint, c.Resource = aad, a.resource()
+		return c.ServicePrincipalToken()
+	default:
+		return nil, fmt.Errorf("%s: no clientSecret or clientCertificate", location)
+	}
+}
+
+// Method returns the authentication method.
+func (a Auth) method() string {
+	if a.Method == "" {
+		return "environment"
+	}
+	return a.Method
+}
+
+// AadEndpoint returns the Active Directory endpoint.
+func (a Auth) aadEndpoint() string {
+	if a.AADEndpoint == "" {
+		return a.cloud().ActiveDirectoryEndpoint
+	}
+	return a.AADEndpoint
+}
+
+// Resource returns the resource to get a token for.
+func (a Auth) resource() string {
+	if a.Resource == "" {
+		return strings.TrimSuffix(a.cloud().KeyVaultEndpoint, "/")
+	}
+	return a.Resource
+}
+
+// Cloud returns the Azure cloud.
+func (a Auth) cloud() azure.Environment {
+	if a.Cloud.KeyVaultEndpoint == "" {
+		return azure.PublicCloud
+	}
+	return a.Cloud
+}
@@ -0,0 +1,262 @@
+package azkv_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/mmlt/tool-tmplt/azkv"
+	"github.com/mmlt/tool-tmplt/azkv/azkvtest"
+	"github.com/mmlt/tool-tmplt/retry"
+	"github.com/stretchr/testify/assert"
+)
+
+// NewAuthServer returns a fake Key Vault that requires a token.
+func newAuthServer() *azkvtest.Server {
+	srv := newServer()
+	srv.Token = "t0k3n"
+	srv.ClientSecret = "s3cret"
+	return srv
+}
+
+func TestAuth_Validate(t *testing.T) {
+	tests := []struct {
+		it   string
+		auth azkv.Auth
+		want string
+	}{
+		{
+			it:   "should default to environment",
+			auth: azkv.Auth{Env: map[string]string{"AZURE_TENANT_ID": "t", "AZURE_CLIENT_ID": "c"}},
+			want: "authentication method environment requires environment variable AZURE_CLIENT_SECRET to be set",
+		},
+		{
+			it:   "should require a certificate path",
+			auth: azkv.Auth{Method: "certificate", Env: map[string]string{"AZURE_TENANT_ID": "t", "AZURE_CLIENT_ID": "c"}},
+			want: "authentication method certificate requires environment variable AZURE_CERTIFICATE_PATH to be set",
+		},
+		{
+			it:   "should require an auth file",
+			auth: azkv.Auth{Method: "file"},
+			want: "authentication method file requires environment variable AZURE_AUTH_LOCATION to be set",
+		},
+		{
+			it:   "should require nothing for cli",
+			auth: azkv.Auth{Method: "cli"},
+		},
+		{
+			it:   "should require nothing for msi",
+			auth: azkv.Auth{Method: "msi"},
+		},
+		{
+			it:   "should reject unknown methods",
+			auth: azkv.Auth{Method: "password"},
+			want: "unknown authentication method password (expected one of environment, cli, msi, certificate, file)",
+		},
+	}
+	for _, tst := range tests {
+		err := tst.auth.Validate()
+		if tst.want == "" {
+			assert.NoError(t, err, tst.it)
+		} else {
+			assert.EqualError(t, err, tst.want, tst.it)
+		}
+	}
+}
+
+func TestLogin_Environment(t *testing.T) {
+	srv := newAuthServer()
+	defer srv.Close()
+
+	a := azkv.Auth{
+		Env: map[string]string{
+			"AZURE_TENANT_ID":     "my-tenant",
+			"AZURE_CLIENT_ID":     "my-client",
+			"AZURE_CLIENT_SECRET": "s3cret",
+		},
+		AADEndpoint: srv.URL + "/",
+	}
+	client, err := azkv.Login(a, retry.Policy{})
+	assert.NoError(t, err)
+	s, _, err := azkv.Get("db-password", "", client, srv.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "rotated-secret", s)
+
+	reqs := srv.TokenRequests()
+	if assert.Len(t, reqs, 1) {
+		assert.Equal(t, "client_credentials", reqs[0].Get("grant_type"))
+		assert.Equal(t, "my-client", reqs[0].Get("client_id"))
+		assert.Equal(t, "https://vault.azure.net", reqs[0].Get("resource"))
+	}
+
+	// wrong credentials are reported by Login
+	a.Env["AZURE_CLIENT_SECRET"] = "wrong"
+	_, err = azkv.Login(a, retry.Policy{})
+	assert.Error(t, err)
+}
+
+func TestLogin_Certificate(t *testing.T) {
+	srv := newAuthServer()
+	defer srv.Close()
+
+	client, err := azkv.Login(azkv.Auth{
+		Method: "certificate",
+		Env: map[string]string{
+			"AZURE_TENANT_ID":        "my-tenant",
+			"AZURE_CLIENT_ID":        "my-client",
+			"AZURE_CERTIFICATE_PATH": "testdata/client.pfx",
+		},
+		AADEndpoint: srv.URL + "/",
+	}, retry.Policy{})
+	assert.NoError(t, err)
+	s, _, err := azkv.Get("db-password", "", client, srv.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "rotated-secret", s)
+
+	reqs := srv.TokenRequests()
+	if assert.Len(t, reqs, 1) {
+		assert.Equal(t, "urn:ietf:params:oauth:client-assertion-type:jwt-bearer", reqs[0].Get("client_assertion_type"))
+		assert.NotEmpty(t, reqs[0].Get("client_assertion"))
+	}
+}
+
+func TestLogin_MSI(t *testing.T) {
+	srv := newAuthServer()
+	defer srv.Close()
+
+	client, err := azkv.Login(azkv.Auth{
+		Method:      "msi",
+		Env:         map[string]string{"AZURE_CLIENT_ID": "my-identity"},
+		MSIEndpoint: srv.URL + "/metadata/identity/oauth2/token",
+	}, retry.Policy{})
+	assert.NoError(t, err)
+	s, _, err := azkv.Get("db-password", "", client, srv.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "rotated-secret", s)
+
+	reqs := srv.TokenRequests()
+	if assert.Len(t, reqs, 1) {
+		assert.Equal(t, "my-identity", reqs[0].Get("client_id"))
+		assert.Equal(t, "https://vault.azure.net", reqs[0].Get("resource"))
+	}
+}
+
+func TestLogin_File(t *testing.T) {
+	srv := newAuthServer()
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "azkv")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "auth.json")
+	err = ioutil.WriteFile(file, []byte(fmt.Sprintf(`{
+  "clientId": "my-client",
+  "clientSecret": "s3cret",
+  "tenantId": "my-tenant",
+  "activeDirectoryEndpointUrl": "%s/"
+}`, srv.URL)), 0600)
+	assert.NoError(t, err)
+
+	client, err := azkv.Login(azkv.Auth{
+		Method: "file",
+		Env:    map[string]string{"AZURE_AUTH_LOCATION": file},
+	}, retry.Policy{})
+	assert.NoError(t, err)
+	s, _, err := azkv.Get("db-password", "", client, srv.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "rotated-secret", s)
+	assert.Len(t, srv.TokenRequests(), 1)
+}
+
+func TestLogin_CLI(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake az cli is a shell script")
+	}
+	srv := newAuthServer()
+	defer srv.Close()
+
+	// put a fake az cli in PATH
+	dir, err := ioutil.TempDir("", "azkv")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	expires := time.Now().Add(time.Hour).Format("2006-01-02 15:04:05.000000")
+	err = ioutil.WriteFile(filepath.Join(dir, "az"), []byte(fmt.Sprintf(`#!/bin/sh
+echo '{"accessToken": "t0k3n", "expiresOn": "%s", "tokenType": "Bearer"}'
+`, expires)), 0700)
+	assert.NoError(t, err)
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+
+	client, err := azkv.Login(azkv.Auth{Method: "cli"}, retry.Policy{})
+	assert.NoError(t, err)
+	s, _, err := azkv.Get("db-password", "", client, srv.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "rotated-secret", s)
+}
+
+func TestLogin_Unauthorized(t *testing.T) {
+	srv := newAuthServer()
+	defer srv.Close()
+
+	// a client without token is rejected by the vault.
+	_, _, err := azkv.Get("db-password", "", srv.NewClient(), srv.URL)
+	assert.Error(t, err)
+}
+
+func TestLogin_Retry(t *testing.T) {
+	srv := newAuthServer()
+	defer srv.Close()
+
+	// throttled and unavailable token requests are retried.
+	srv.Failures = []int{429, 503}
+	client, err := azkv.Login(azkv.Auth{
+		Env: map[string]string{
+			"AZURE_TENANT_ID":     "my-tenant",
+			"AZURE_CLIENT_ID":     "my-client",
+			"AZURE_CLIENT_SECRET": "s3cret",
+		},
+		AADEndpoint: srv.URL + "/",
+	}, retry.Policy{Retries: 2, Backoff: time.Millisecond})
+	assert.NoError(t, err)
+	assert.Len(t, srv.TokenRequests(), 1)
+	assert.Equal(t, 0, srv.Calls())
+
+	// throttled and unavailable calls are retried.
+	srv.Failures = []int{429, 503}
+	s, _, err := azkv.Get("db-password", "", client, srv.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "rotated-secret", s)
+	assert.Equal(t, 3, srv.Calls())
+
+	// a call fails when retries are exhausted (the SDK doesn't retry 429 forever).
+	srv.Failures = []int{429, 429, 429, 429}
+	_, _, err = azkv.Get("db-password", "", client, srv.URL)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "429 Too Many Requests after 3 attempts")
+	}
+	assert.Equal(t, 6, srv.Calls())
+}
+
+func TestLogin_Cloud(t *testing.T) {
+	srv := newAuthServer()
+	defer srv.Close()
+	usgov, err := azkv.Cloud("usgovernment", nil)
+	assert.NoError(t, err)
+
+	_, err = azkv.Login(azkv.Auth{
+		Method:      "msi",
+		Cloud:       usgov,
+		MSIEndpoint: srv.URL + "/metadata/identity/oauth2/token",
+	}, retry.Policy{})
+	assert.NoError(t, err)
+
+	reqs := srv.TokenRequests()
+	if assert.Len(t, reqs, 1) {
+		assert.Equal(t, "https://vault.usgovcloudapi.net", reqs[0].Get("resource"))
+	}
+}
@@ -3,32 +3,160 @@ package azkv
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
 	"github.com/Azure/azure-sdk-for-go/profiles/latest/keyvault/keyvault"
-	"github.com/Azure/azure-sdk-for-go/services/keyvault/auth"
+	"gopkg.in/yaml.v2"
 )
 
 // Azure Key Vault
 // https://docs.microsoft.com/en-us/azure/key-vault/about-keys-secrets-and-certificates
 
-// Authenticate via environment variables AZURE_TENANT_ID, AZURE_CLIENT_ID, AZURE_CLIENT_SECRET
-// https://docs.microsoft.com/en-us/azure/go/azure-sdk-go-authorization#use-environment-based-authentication
+// Get returns the value and version of a secret.
+// When version is empty the latest version is returned.
+func Get(name, version string, client *keyvault.BaseClient, url string) (string, string, error) {
+	secret, err := getSecret(name, version, client, url)
+	return secret.value, secret.version, err
+}
+
+// GetLocked returns the value of a secret like Get and records the version used in 'lock' (when not nil).
+// When 'frozen' is true and version is empty the version recorded in 'lock' is used.
+func GetLocked(name, version string, client *keyvault.BaseClient, url string, lock *Lock, frozen bool) (string, error) {
+	secret, err := getLocked(name, version, client, url, lock, frozen)
+	return secret.value, err
+}
+
+// Secret is a version of a secret.
+type secret struct {
+	value, version, contentType string
+}
 
-func Login(url string) (*keyvault.BaseClient, error) {
-	authorizer, err := auth.NewAuthorizerFromEnvironment()
+// GetSecret returns a version of a secret, the latest when version is empty.
+func getSecret(name, version string, client *keyvault.BaseClient, url string) (secret, error) {
+	secretResp, err := client.GetSecret(context.Background(), url, name, version)
 	if err != nil {
-		return nil, err
+		if version != "" {
+			return secret{}, fmt.Errorf("no secret %s version %s: %v", name, version, err)
+		}
+		return secret{}, fmt.Errorf("no secret %s: %v", name, err)
+	}
+	if secretResp.Value == nil {
+		return secret{}, fmt.Errorf("secret %s has no value", name)
+	}
+	if secretResp.ID != nil {
+		// id is https://name-of-keyvault.vault.azure.net/secrets/name-of-secret/version
+		version = path.Base(*secretResp.ID)
+	}
+	s := secret{value: *secretResp.Value, version: version}
+	if secretResp.ContentType != nil {
+		s.contentType = *secretResp.ContentType
 	}
+	return s, nil
+}
 
-	basicClient := keyvault.New()
-	basicClient.Authorizer = authorizer
+// GetLocked returns a secret like getSecret and records the version used in 'lock' (when not nil).
+// When 'frozen' is true and version is empty the version recorded in 'lock' is used.
+func getLocked(name, version string, client *keyvault.BaseClient, url string, lock *Lock, frozen bool) (secret, error) {
+	if version == "" && frozen {
+		var ok bool
+		version, ok = lock.Version(url, name)
+		if !ok {
+			return secret{}, fmt.Errorf("secret %s is not locked", name)
+		}
+	}
+	s, err := getSecret(name, version, client, url)
+	if err != nil {
+		return secret{}, err
+	}
+	if lock != nil && !frozen {
+		lock.Set(url, name, s.version)
+	}
+	return s, nil
+}
+
+// Lock records the secret, certificate and key versions used during expansion.
+// It is stored in yaml format like:
+//   secrets:
+//     https://name-of-keyvault.vault.azure.net:
+//       name-of-secret: 4387e9f3d6e14c459867679a90fd0f79
+//   certificates:
+//     https://name-of-keyvault.vault.azure.net:
+//       name-of-certificate: 9a4b8e3f0c1d4e2f8a7b6c5d4e3f2a1b
+type Lock struct {
+	// Secrets maps vault url to secret name to version.
+	Secrets map[string]map[string]string `yaml:"secrets"`
+	// Certificates and Keys map vault url to certificate or key name to version.
+	Certificates map[string]map[string]string `yaml:"certificates,omitempty"`
+	Keys         map[string]map[string]string `yaml:"keys,omitempty"`
+}
 
-	return &basicClient, nil
+// ReadLock returns the Lock stored in 'file'.
+// A non-existing file results in an empty Lock.
+func ReadLock(file string) (*Lock, error) {
+	l := &Lock{Secrets: make(map[string]map[string]string)}
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = yaml.Unmarshal(b, l)
+	if err != nil {
+		return nil, fmt.Errorf("parsing: %v", err)
+	}
+	if l.Secrets == nil {
+		l.Secrets = make(map[string]map[string]string)
+	}
+	return l, nil
 }
 
-func Get(name string, client *keyvault.BaseClient, url string) (string, error) {
-	secretResp, err := client.GetSecret(context.Background(), url, name, "")
+// Write stores the Lock in 'file'.
+func (l *Lock) Write(file string) error {
+	b, err := yaml.Marshal(l)
 	if err != nil {
-		return "", fmt.Errorf("no secret %s: %v", name, err)
+		return err
+	}
+	return ioutil.WriteFile(file, b, 0644)
+}
+
+// Version returns the locked version of secret 'name' in vault 'url'.
+func (l *Lock) Version(url, name string) (string, bool) {
+	if l == nil {
+		return "", false
+	}
+	v, ok := l.Secrets[url][name]
+	return v, ok
+}
+
+// Set records the version of secret 'name' in vault 'url'.
+func (l *Lock) Set(url, name, version string) {
+	l.Secrets = set(l.Secrets, url, name, version)
+}
+
+// Set records 'version' in 'm' and returns 'm', a nil map is allocated.
+func set(m map[string]map[string]string, url, name, version string) map[string]map[string]string {
+	if m == nil {
+		m = make(map[string]map[string]string)
+	}
+	if m[url] == nil {
+		m[url] = make(map[string]string)
+	}
+	m[url][name] = version
+	return m
+}
+
+// Pinned returns the version to get of a certificate or key recorded in 'versions'.
+// When 'frozen' is false the latest version ("") is returned.
+func pinned(kind string, versions map[string]map[string]string, url, name string, frozen bool) (string, error) {
+	if !frozen {
+		return "", nil
+	}
+	v, ok := versions[url][name]
+	if !ok {
+		return "", fmt.Errorf("%s %s is not locked", kind, name)
 	}
-	return *secretResp.Value, nil
+	return v, nil
 }
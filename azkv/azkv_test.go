@@ -0,0 +1,70 @@
+package azkv_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mmlt/tool-tmplt/azkv"
+	"github.com/mmlt/tool-tmplt/azkv/azkvtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// NewServer returns a fake Key Vault with 2 versions of secret 'db-password'.
+func newServer() *azkvtest.Server {
+	srv := azkvtest.NewServer()
+	srv.Secrets["db-password"] = []azkvtest.Secret{
+		{Version: "v1", Value: "first-secret"},
+		{Version: "v2", Value: "rotated-secret"},
+	}
+	return srv
+}
+
+func TestGet(t *testing.T) {
+	srv := newServer()
+	defer srv.Close()
+	client := srv.NewClient()
+
+	s, v, err := azkv.Get("db-password", "", client, srv.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "rotated-secret", s)
+	assert.Equal(t, "v2", v)
+
+	s, v, err = azkv.Get("db-password", "v1", client, srv.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "first-secret", s)
+	assert.Equal(t, "v1", v)
+
+	_, _, err = azkv.Get("db-password", "v3", client, srv.URL)
+	assert.Error(t, err)
+}
+
+func TestGetLocked(t *testing.T) {
+	srv := newServer()
+	defer srv.Close()
+	client := srv.NewClient()
+
+	dir, err := ioutil.TempDir("", "azkv")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "secrets.lock")
+
+	// record
+	lock, err := azkv.ReadLock(file)
+	assert.NoError(t, err)
+	s, err := azkv.GetLocked("db-password", "v1", client, srv.URL, lock, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "first-secret", s)
+	assert.NoError(t, lock.Write(file))
+
+	// replay
+	lock, err = azkv.ReadLock(file)
+	assert.NoError(t, err)
+	s, err = azkv.GetLocked("db-password", "", client, srv.URL, lock, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "first-secret", s)
+
+	_, err = azkv.GetLocked("api-key", "", client, srv.URL, lock, true)
+	assert.EqualError(t, err, "secret api-key is not locked")
+}
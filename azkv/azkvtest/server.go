@@ -0,0 +1,349 @@
+// Package azkvtest provides a fake Azure Key Vault endpoint for testing.
+package azkvtest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/keyvault/keyvault"
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// Server is a fake Key Vault that serves the REST calls used by the azkv package.
+// Use URL as the vault url.
+type Server struct {
+	*httptest.Server
+
+	// Secrets are the versions of a secret by name, the last version is the latest.
+	Secrets map[string][]Secret
+	// Certificates are the DER encoded x509 certificates by name.
+	// (the backing secret should be added to Secrets)
+	Certificates map[string][]byte
+	// Keys are the public keys (*rsa.PublicKey or *ecdsa.PublicKey) by name.
+	Keys map[string]crypto.PublicKey
+
+	// Token when not empty is the access token handed out by the token endpoints and required by the Key Vault
+	// endpoints.
+	// The server serves Active Directory tokens at URL/{tenant}/oauth2/token and managed identity tokens at
+	// URL/metadata/identity/oauth2/token.
+	Token string
+	// PageSize is the maximum number of items in a list response, 0 means 25.
+	PageSize int
+	// Failures are HTTP statuses that are returned for the next Key Vault and token requests, one per request.
+	// A 0 status doesn't fail the request.
+	Failures []int
+	// ClientSecret when not empty is the only client secret accepted by the Active Directory token endpoint.
+	ClientSecret string
+
+	mu            sync.Mutex
+	calls         int
+	tokenRequests []url.Values
+}
+
+// Secret is a version of a Key Vault secret.
+type Secret struct {
+	Version     string
+	Value       string
+	ContentType string
+	Tags        map[string]string
+	// Disabled secrets can be listed but not read.
+	Disabled bool
+	// Expires and NotBefore are zero when not set.
+	Expires, NotBefore time.Time
+}
+
+// NewServer starts a fake Key Vault.
+// The caller should call Close when finished.
+func NewServer() *Server {
+	s := &Server{
+		Secrets:      make(map[string][]Secret),
+		Certificates: make(map[string][]byte),
+		Keys:         make(map[string]crypto.PublicKey),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// NewClient returns a Key Vault client that doesn't authenticate.
+func (s *Server) NewClient() *keyvault.BaseClient {
+	c := keyvault.New()
+	c.Authorizer = autorest.NullAuthorizer{}
+	return &c
+}
+
+// TokenRequests returns the parameters of the token requests served.
+func (s *Server) TokenRequests() []url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]url.Values(nil), s.tokenRequests...)
+}
+
+// Calls returns the number of Key Vault requests served.
+func (s *Server) Calls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+// Handle serves a Key Vault or token request.
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	token := strings.HasSuffix(r.URL.Path, "/oauth2/token")
+
+	s.mu.Lock()
+	if !token {
+		s.calls++
+	}
+	failure := 0
+	if len(s.Failures) > 0 {
+		failure, s.Failures = s.Failures[0], s.Failures[1:]
+	}
+	s.mu.Unlock()
+
+	if failure != 0 {
+		writeError(w, failure, "Throttled", http.StatusText(failure))
+		return
+	}
+
+	if token {
+		s.handleToken(w, r)
+		return
+	}
+
+	if s.Token != "" && r.Header.Get("Authorization") != "Bearer "+s.Token {
+		writeError(w, http.StatusUnauthorized, "Unauthorized", "AKV10000: Request is missing a Bearer or PoP token.")
+		return
+	}
+
+	// path is /{collection}/{name}/{version}
+	p := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if r.Method == http.MethodGet && len(p) == 1 && p[0] == "secrets" {
+		s.listSecrets(w, r)
+		return
+	}
+	if r.Method != http.MethodGet || len(p) < 2 {
+		writeError(w, http.StatusBadRequest, "BadParameter", "The request URI is invalid.")
+		return
+	}
+	name, version := p[1], ""
+	if len(p) > 2 {
+		version = p[2]
+	}
+
+	switch p[0] {
+	case "secrets":
+		secret, ok := s.secret(name, version)
+		if !ok {
+			writeNotFound(w, "SecretNotFound", "secret", name, version)
+			return
+		}
+		if secret.Disabled {
+			writeError(w, http.StatusForbidden, "Forbidden", "Operation get is not allowed on a disabled secret.")
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"value":       secret.Value,
+			"id":          fmt.Sprintf("%s/secrets/%s/%s", s.URL, name, secret.Version),
+			"contentType": secret.ContentType,
+			"tags":        secret.Tags,
+			"attributes":  secret.attributes(),
+		})
+	case "certificates":
+		// certificates and keys have one version named 1.
+		cer, ok := s.Certificates[name]
+		if !ok || (version != "" && version != "1") {
+			writeNotFound(w, "CertificateNotFound", "certificate", name, version)
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"id":         fmt.Sprintf("%s/certificates/%s/1", s.URL, name),
+			"sid":        fmt.Sprintf("%s/secrets/%s/1", s.URL, name),
+			"cer":        cer,
+			"attributes": map[string]interface{}{"enabled": true},
+		})
+	case "keys":
+		key, ok := s.Keys[name]
+		if !ok || (version != "" && version != "1") {
+			writeNotFound(w, "KeyNotFound", "key", name, version)
+			return
+		}
+		jwk, err := toJWK(key)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "InternalError", err.Error())
+			return
+		}
+		jwk["kid"] = fmt.Sprintf("%s/keys/%s/1", s.URL, name)
+		writeJSON(w, map[string]interface{}{
+			"key":        jwk,
+			"attributes": map[string]interface{}{"enabled": true},
+		})
+	default:
+		writeError(w, http.StatusBadRequest, "BadParameter", "The request URI is invalid.")
+	}
+}
+
+// ListSecrets serves a page of the list of latest secret versions.
+// The page is selected by the $skiptoken parameter (the index of the first item).
+func (s *Server) listSecrets(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(s.Secrets))
+	for n := range s.Secrets {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	size := s.PageSize
+	if size <= 0 {
+		size = 25
+	}
+	start, _ := strconv.Atoi(r.URL.Query().Get("$skiptoken"))
+	if start < 0 || start > len(names) {
+		start = len(names)
+	}
+	end := start + size
+	if end > len(names) {
+		end = len(names)
+	}
+
+	items := []map[string]interface{}{}
+	for _, n := range names[start:end] {
+		secret, _ := s.secret(n, "")
+		items = append(items, map[string]interface{}{
+			"id":          fmt.Sprintf("%s/secrets/%s", s.URL, n),
+			"contentType": secret.ContentType,
+			"tags":        secret.Tags,
+			"attributes":  secret.attributes(),
+		})
+	}
+	result := map[string]interface{}{"value": items}
+	if end < len(names) {
+		result["nextLink"] = fmt.Sprintf("%s/secrets?api-version=%s&$skiptoken=%d", s.URL, r.URL.Query().Get("api-version"), end)
+	}
+	writeJSON(w, result)
+}
+
+// Attributes returns the Key Vault attributes of a secret.
+func (secret Secret) attributes() map[string]interface{} {
+	a := map[string]interface{}{"enabled": !secret.Disabled}
+	if !secret.Expires.IsZero() {
+		a["exp"] = secret.Expires.Unix()
+	}
+	if !secret.NotBefore.IsZero() {
+		a["nbf"] = secret.NotBefore.Unix()
+	}
+	return a
+}
+
+// HandleToken serves an Active Directory (POST) or managed identity (GET) token request.
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	s.mu.Lock()
+	s.tokenRequests = append(s.tokenRequests, r.Form)
+	s.mu.Unlock()
+
+	if r.Method == http.MethodGet && r.Header.Get("Metadata") != "true" {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request", "Required metadata header not specified")
+		return
+	}
+	if s.ClientSecret != "" && r.Form.Get("client_secret") != "" && r.Form.Get("client_secret") != s.ClientSecret {
+		writeTokenError(w, http.StatusUnauthorized, "invalid_client", "AADSTS7000215: Invalid client secret is provided.")
+		return
+	}
+	if s.Token == "" {
+		writeTokenError(w, http.StatusBadRequest, "unauthorized_client", "no token configured")
+		return
+	}
+
+	now := time.Now().Unix()
+	writeJSON(w, map[string]string{
+		"access_token": s.Token,
+		"token_type":   "Bearer",
+		"expires_in":   "3600",
+		"expires_on":   strconv.FormatInt(now+3600, 10),
+		"not_before":   strconv.FormatInt(now, 10),
+		"resource":     r.Form.Get("resource"),
+	})
+}
+
+// WriteTokenError writes an OAuth2 error response.
+func writeTokenError(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": code, "error_description": msg})
+}
+
+// ToJWK returns the JSON Web Key representation of a public key.
+func toJWK(key crypto.PublicKey) (map[string]interface{}, error) {
+	enc := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return map[string]interface{}{
+			"kty":     "RSA",
+			"key_ops": []string{"encrypt", "decrypt", "sign", "verify"},
+			"n":       enc(k.N.Bytes()),
+			"e":       enc(big.NewInt(int64(k.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		return map[string]interface{}{
+			"kty":     "EC",
+			"key_ops": []string{"sign", "verify"},
+			"crv":     k.Curve.Params().Name,
+			"x":       enc(k.X.Bytes()),
+			"y":       enc(k.Y.Bytes()),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+// Secret returns a version of a secret, the latest when version is empty.
+func (s *Server) secret(name, version string) (Secret, bool) {
+	vs := s.Secrets[name]
+	if len(vs) == 0 {
+		return Secret{}, false
+	}
+	if version == "" {
+		return vs[len(vs)-1], true
+	}
+	for _, v := range vs {
+		if v.Version == version {
+			return v, true
+		}
+	}
+	return Secret{}, false
+}
+
+// WriteJSON writes a 200 OK response with 'v' as body.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// WriteNotFound writes a Key Vault not found response.
+func writeNotFound(w http.ResponseWriter, code, kind, name, version string) {
+	writeError(w, http.StatusNotFound, code,
+		fmt.Sprintf("A %s with (name/id) %s/%s was not found in this key vault.", kind, name, version))
+}
+
+// WriteError writes a Key Vault error response.
+func writeError(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"code": code, "message": msg},
+	})
+}
@@ -0,0 +1,205 @@
+package azkv
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"path"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/keyvault/keyvault"
+	"golang.org/x/crypto/pkcs12"
+)
+
+// Key Vault certificates are backed by a key and a secret with the same name.
+// https://docs.microsoft.com/en-us/azure/key-vault/about-keys-secrets-and-certificates#composition-of-a-certificate
+
+// GetCertificate returns the public x509 certificate in PEM format.
+// The version used is recorded in 'lock' (when not nil), when 'frozen' is true the version recorded in 'lock' is used.
+func GetCertificate(name string, client *keyvault.BaseClient, url string, lock *Lock, frozen bool) (string, error) {
+	var versions map[string]map[string]string
+	if lock != nil {
+		versions = lock.Certificates
+	}
+	version, err := pinned("certificate", versions, url, name, frozen)
+	if err != nil {
+		return "", err
+	}
+	certResp, err := client.GetCertificate(context.Background(), url, name, version)
+	if err != nil {
+		return "", fmt.Errorf("no certificate %s: %v", name, err)
+	}
+	if certResp.Cer == nil {
+		return "", fmt.Errorf("certificate %s has no cer", name)
+	}
+	if lock != nil && !frozen && certResp.ID != nil {
+		// id is https://name-of-keyvault.vault.azure.net/certificates/name-of-certificate/version
+		lock.Certificates = set(lock.Certificates, url, name, path.Base(*certResp.ID))
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: *certResp.Cer})), nil
+}
+
+// GetCertificateBundle returns the private key followed by the certificate chain in PEM format.
+// The bundle is read from the secret that backs the certificate, its version is locked like a secret, see GetLocked.
+func GetCertificateBundle(name string, client *keyvault.BaseClient, url string, lock *Lock, frozen bool) (string, error) {
+	s, err := getLocked(name, "", client, url, lock, frozen)
+	if err != nil {
+		return "", fmt.Errorf("certificate %s: %v", name, err)
+	}
+
+	switch s.contentType {
+	case "application/x-pkcs12":
+		pfx, err := base64.StdEncoding.DecodeString(s.value)
+		if err != nil {
+			return "", fmt.Errorf("certificate secret %s: %v", name, err)
+		}
+		s, err := PKCS12ToPEM(pfx, "")
+		if err != nil {
+			return "", fmt.Errorf("certificate secret %s: %v", name, err)
+		}
+		return s, nil
+	case "application/x-pem-file":
+		return s.value, nil
+	default:
+		return "", fmt.Errorf("certificate secret %s has unsupported content type %q", name, s.contentType)
+	}
+}
+
+// PKCS12ToPEM converts PKCS#12 (PFX) data to PEM.
+// The private key(s) are returned first, followed by the certificates in PFX order (typically leaf first).
+func PKCS12ToPEM(pfx []byte, password string) (string, error) {
+	blocks, err := pkcs12.ToPEM(pfx, password)
+	if err != nil {
+		return "", err
+	}
+	var keys, certs strings.Builder
+	for _, b := range blocks {
+		// drop PKCS#12 bag attributes like localKeyId.
+		b.Headers = nil
+		if b.Type == "CERTIFICATE" {
+			certs.Write(pem.EncodeToMemory(b))
+			continue
+		}
+		// pkcs12.ToPEM returns PKCS#1 or SEC1 keys as "PRIVATE KEY", re-encode them as PKCS#8.
+		b.Bytes, err = toPKCS8(b.Bytes)
+		if err != nil {
+			return "", err
+		}
+		keys.Write(pem.EncodeToMemory(b))
+	}
+	return keys.String() + certs.String(), nil
+}
+
+// ToPKCS8 converts a PKCS#1 (RSA) or SEC1 (EC) private key to PKCS#8.
+func toPKCS8(der []byte) ([]byte, error) {
+	if k, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return x509.MarshalPKCS8PrivateKey(k)
+	}
+	if k, err := x509.ParseECPrivateKey(der); err == nil {
+		return x509.MarshalPKCS8PrivateKey(k)
+	}
+	return nil, fmt.Errorf("unknown private key type")
+}
+
+// GetKey returns the public part of a key in 'format' pem (PKIX) or jwk (JSON Web Key).
+// The version used is recorded in 'lock' (when not nil), when 'frozen' is true the version recorded in 'lock' is used.
+func GetKey(name, format string, client *keyvault.BaseClient, url string, lock *Lock, frozen bool) (string, error) {
+	var versions map[string]map[string]string
+	if lock != nil {
+		versions = lock.Keys
+	}
+	version, err := pinned("key", versions, url, name, frozen)
+	if err != nil {
+		return "", err
+	}
+	keyResp, err := client.GetKey(context.Background(), url, name, version)
+	if err != nil {
+		return "", fmt.Errorf("no key %s: %v", name, err)
+	}
+	if keyResp.Key == nil {
+		return "", fmt.Errorf("key %s has no value", name)
+	}
+	if lock != nil && !frozen && keyResp.Key.Kid != nil {
+		// kid is https://name-of-keyvault.vault.azure.net/keys/name-of-key/version
+		lock.Keys = set(lock.Keys, url, name, path.Base(*keyResp.Key.Kid))
+	}
+
+	switch format {
+	case "jwk":
+		b, err := json.Marshal(keyResp.Key)
+		if err != nil {
+			return "", fmt.Errorf("key %s: %v", name, err)
+		}
+		return string(b), nil
+	case "pem", "":
+		pub, err := publicKey(keyResp.Key)
+		if err != nil {
+			return "", fmt.Errorf("key %s: %v", name, err)
+		}
+		b, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return "", fmt.Errorf("key %s: %v", name, err)
+		}
+		return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: b})), nil
+	default:
+		return "", fmt.Errorf("key %s: unsupported format %q (expected pem or jwk)", name, format)
+	}
+}
+
+// PublicKey returns the RSA or EC public key of a JSON Web Key.
+func publicKey(k *keyvault.JSONWebKey) (interface{}, error) {
+	switch k.Kty {
+	case keyvault.RSA, keyvault.RSAHSM:
+		n, err := decodeJWKInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("n: %v", err)
+		}
+		e, err := decodeJWKInt(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("e: %v", err)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case keyvault.EC, keyvault.ECHSM:
+		var curve elliptic.Curve
+		switch k.Crv {
+		case keyvault.P256:
+			curve = elliptic.P256()
+		case keyvault.P384:
+			curve = elliptic.P384()
+		case keyvault.P521:
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeJWKInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("x: %v", err)
+		}
+		y, err := decodeJWKInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("y: %v", err)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// DecodeJWKInt decodes a base64url encoded big-endian integer.
+func decodeJWKInt(s *string) (*big.Int, error) {
+	if s == nil {
+		return nil, fmt.Errorf("missing")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(*s, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,92 @@
+package azkv
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+// Clouds are the Azure clouds by (short) name.
+var clouds = map[string]azure.Environment{
+	"public":       azure.PublicCloud,
+	"usgovernment": azure.USGovernmentCloud,
+	"china":        azure.ChinaCloud,
+	"german":       azure.GermanCloud,
+}
+
+// VaultNameRE matches Key Vault names.
+var vaultNameRE = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9\-]{1,22}[a-zA-Z0-9]$`)
+
+// Cloud returns the Azure cloud with 'name'.
+// Name is a short name (public, usgovernment, china, german), an SDK name (AzurePublicCloud, AzureUSGovernmentCloud,
+// AzureChinaCloud, AzureGermanCloud) or AzureStackCloud for the custom endpoints in the file at
+// AZURE_ENVIRONMENT_FILEPATH. A name ending in .json is read as a file with custom endpoints.
+// An empty name means the cloud named by AZURE_ENVIRONMENT, the public cloud when that is not set.
+func Cloud(name string, env map[string]string) (azure.Environment, error) {
+	if name == "" {
+		name = env["AZURE_ENVIRONMENT"]
+	}
+	if name == "" {
+		return azure.PublicCloud, nil
+	}
+	if c, ok := clouds[strings.ToLower(name)]; ok {
+		return c, nil
+	}
+
+	file := ""
+	switch {
+	case strings.HasSuffix(name, ".json"):
+		file = name
+	case strings.EqualFold(name, "AzureStackCloud"):
+		file = env[azure.EnvironmentFilepathName]
+		if file == "" {
+			return azure.Environment{}, fmt.Errorf("cloud %s requires environment variable %s to be set",
+				name, azure.EnvironmentFilepathName)
+		}
+	default:
+		if strings.HasPrefix(strings.ToLower(name), "azure") {
+			if c, err := azure.EnvironmentFromName(name); err == nil {
+				return c, nil
+			}
+		}
+		return azure.Environment{}, fmt.Errorf("unknown cloud %s (expected public, usgovernment, china, german, AzureStackCloud or a .json file)", name)
+	}
+
+	c, err := azure.EnvironmentFromFile(file)
+	if err != nil {
+		return azure.Environment{}, fmt.Errorf("cloud %s: %v", name, err)
+	}
+	if c.ActiveDirectoryEndpoint == "" || c.KeyVaultEndpoint == "" || c.KeyVaultDNSSuffix == "" {
+		return azure.Environment{}, fmt.Errorf("cloud %s: activeDirectoryEndpoint, keyVaultEndpoint and keyVaultDNSSuffix should be set", name)
+	}
+	return c, nil
+}
+
+// Expand replaces the vault names by urls in 'cloud' and checks that vault urls in a known cloud are in 'cloud'.
+// For example in the public cloud name-of-keyvault becomes https://name-of-keyvault.vault.azure.net
+func (v Vaults) Expand(cloud azure.Environment) error {
+	for _, a := range v.aliases() {
+		s := v[a]
+		if !strings.Contains(s, "://") {
+			if !vaultNameRE.MatchString(s) {
+				return fmt.Errorf("vault %s: %q is not a vault name or url", a, s)
+			}
+			v[a] = "https://" + s + "." + cloud.KeyVaultDNSSuffix
+			continue
+		}
+		u, err := url.Parse(s)
+		if err != nil {
+			return fmt.Errorf("vault %s: %v", a, err)
+		}
+		host := strings.ToLower(u.Hostname())
+		for _, c := range clouds {
+			if c.KeyVaultDNSSuffix != cloud.KeyVaultDNSSuffix && strings.HasSuffix(host, "."+c.KeyVaultDNSSuffix) {
+				return fmt.Errorf("vault %s: url %q is in %s, not in %s", a, s, c.Name, cloud.Name)
+			}
+		}
+	}
+	return nil
+}
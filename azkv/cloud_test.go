@@ -0,0 +1,98 @@
+package azkv_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mmlt/tool-tmplt/azkv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCloud(t *testing.T) {
+	dir, err := ioutil.TempDir("", "azkv")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "stack.json")
+	err = ioutil.WriteFile(file, []byte(`{
+  "name": "AzureStackCloud",
+  "activeDirectoryEndpoint": "https://login.stack.example.com/",
+  "keyVaultEndpoint": "https://vault.stack.example.com/",
+  "keyVaultDNSSuffix": "vault.stack.example.com"
+}`), 0600)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		it      string
+		name    string
+		env     map[string]string
+		want    string
+		wantErr string
+	}{
+		{
+			it:   "should default to the public cloud",
+			want: "vault.azure.net",
+		},
+		{
+			it:   "should use AZURE_ENVIRONMENT",
+			env:  map[string]string{"AZURE_ENVIRONMENT": "AzureUSGovernmentCloud"},
+			want: "vault.usgovcloudapi.net",
+		},
+		{
+			it:   "should prefer name over AZURE_ENVIRONMENT",
+			name: "china",
+			env:  map[string]string{"AZURE_ENVIRONMENT": "AzureUSGovernmentCloud"},
+			want: "vault.azure.cn",
+		},
+		{
+			it:   "should read custom endpoints from AZURE_ENVIRONMENT_FILEPATH",
+			name: "AzureStackCloud",
+			env:  map[string]string{"AZURE_ENVIRONMENT_FILEPATH": file},
+			want: "vault.stack.example.com",
+		},
+		{
+			it:   "should read custom endpoints from a json file",
+			name: file,
+			want: "vault.stack.example.com",
+		},
+		{
+			it:      "should reject unknown clouds",
+			name:    "mars",
+			wantErr: "unknown cloud mars (expected public, usgovernment, china, german, AzureStackCloud or a .json file)",
+		},
+		{
+			it:      "should require a file for AzureStackCloud",
+			name:    "AzureStackCloud",
+			wantErr: "cloud AzureStackCloud requires environment variable AZURE_ENVIRONMENT_FILEPATH to be set",
+		},
+	}
+	for _, tst := range tests {
+		got, err := azkv.Cloud(tst.name, tst.env)
+		if tst.wantErr != "" {
+			assert.EqualError(t, err, tst.wantErr, tst.it)
+			continue
+		}
+		assert.NoError(t, err, tst.it)
+		assert.Equal(t, tst.want, got.KeyVaultDNSSuffix, tst.it)
+	}
+}
+
+func TestVaults_Expand(t *testing.T) {
+	usgov, err := azkv.Cloud("usgovernment", nil)
+	assert.NoError(t, err)
+
+	v := azkv.Vaults{"": "platform-kv", "team": "https://team-kv.vault.usgovcloudapi.net", "local": "http://127.0.0.1:8080"}
+	assert.NoError(t, v.Expand(usgov))
+	assert.Equal(t, azkv.Vaults{
+		"":      "https://platform-kv.vault.usgovcloudapi.net",
+		"team":  "https://team-kv.vault.usgovcloudapi.net",
+		"local": "http://127.0.0.1:8080",
+	}, v)
+
+	v = azkv.Vaults{"team": "team_kv"}
+	assert.EqualError(t, v.Expand(usgov), `vault team: "team_kv" is not a vault name or url`)
+
+	v = azkv.Vaults{"team": "https://team-kv.vault.azure.net"}
+	assert.EqualError(t, v.Expand(usgov), `vault team: url "https://team-kv.vault.azure.net" is in AzurePublicCloud, not in AzureUSGovernmentCloud`)
+}
@@ -0,0 +1,75 @@
+package azkv
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/keyvault/keyvault"
+)
+
+// GetDecoded returns the value of a secret decoded according to its content type, see Decode.
+// The version used is recorded in 'lock' like GetLocked does.
+func GetDecoded(name, version string, client *keyvault.BaseClient, url string, lock *Lock, frozen bool) (interface{}, error) {
+	s, err := getLocked(name, version, client, url, lock, frozen)
+	if err != nil {
+		return nil, err
+	}
+	v, err := Decode(s.value, s.contentType)
+	if err != nil {
+		return nil, fmt.Errorf("secret %s: %v", name, err)
+	}
+	return v, nil
+}
+
+// Decode decodes a secret value according to its content type:
+//
+//	"", text/plain - the value as is
+//	application/json - the decoded JSON (a map for a JSON object)
+//	application/x-pkcs12 - the base64 encoded PFX converted to PEM (private keys followed by certificates)
+//	application/x-pem-file - the value as is
+//	application/octet-stream or any type with parameter encoding=base64 - the base64 decoded bytes as string
+//
+// Other content types are an error.
+func Decode(value, contentType string) (interface{}, error) {
+	if strings.TrimSpace(contentType) == "" {
+		return value, nil
+	}
+	mt, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("content type %q: %v", contentType, err)
+	}
+
+	if mt == "application/octet-stream" || strings.EqualFold(params["encoding"], "base64") {
+		b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("content type %s: %v", contentType, err)
+		}
+		return string(b), nil
+	}
+
+	switch mt {
+	case "text/plain", "application/x-pem-file":
+		return value, nil
+	case "application/json":
+		var v interface{}
+		if err := json.Unmarshal([]byte(value), &v); err != nil {
+			return nil, fmt.Errorf("content type %s: %v", contentType, err)
+		}
+		return v, nil
+	case "application/x-pkcs12":
+		pfx, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("content type %s: %v", contentType, err)
+		}
+		s, err := PKCS12ToPEM(pfx, "")
+		if err != nil {
+			return nil, fmt.Errorf("content type %s: %v", contentType, err)
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unsupported content type %q", contentType)
+	}
+}
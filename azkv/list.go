@@ -0,0 +1,82 @@
+package azkv
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/keyvault/keyvault"
+)
+
+// Filter selects the secrets returned by List.
+type Filter struct {
+	// Prefix selects the secrets with a name that starts with Prefix (empty selects all).
+	Prefix string
+	// Tag when not empty selects the secrets that have tag Tag with value TagValue.
+	Tag, TagValue string
+	// All includes disabled, expired and not yet valid secrets.
+	All bool
+}
+
+// List returns the sorted names of the secrets in vault 'url' that match 'filter'.
+// All pages of the Key Vault secret list are read.
+func List(filter Filter, client *keyvault.BaseClient, url string) ([]string, error) {
+	ctx := context.Background()
+	it, err := client.GetSecretsComplete(ctx, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("list secrets: %v", err)
+	}
+
+	var names []string
+	now := time.Now()
+	for it.NotDone() {
+		item := it.Value()
+		if item.ID != nil {
+			// id is https://name-of-keyvault.vault.azure.net/secrets/name-of-secret
+			name := path.Base(*item.ID)
+			if filter.match(name, item, now) {
+				names = append(names, name)
+			}
+		}
+		if err := it.NextWithContext(ctx); err != nil {
+			return nil, fmt.Errorf("list secrets: %v", err)
+		}
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// Match returns true when secret 'name' with list 'item' is selected by the filter.
+func (f Filter) match(name string, item keyvault.SecretItem, now time.Time) bool {
+	if !strings.HasPrefix(name, f.Prefix) {
+		return false
+	}
+	if f.Tag != "" {
+		v, ok := item.Tags[f.Tag]
+		if !ok || v == nil || *v != f.TagValue {
+			return false
+		}
+	}
+	return f.All || isValid(item.Attributes, now)
+}
+
+// IsValid returns true when the secret with attributes 'a' is enabled and valid at 'now'.
+func isValid(a *keyvault.SecretAttributes, now time.Time) bool {
+	if a == nil {
+		return true
+	}
+	if a.Enabled != nil && !*a.Enabled {
+		return false
+	}
+	if a.Expires != nil && !time.Time(*a.Expires).After(now) {
+		return false
+	}
+	if a.NotBefore != nil && time.Time(*a.NotBefore).After(now) {
+		return false
+	}
+	return true
+}
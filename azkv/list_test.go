@@ -0,0 +1,63 @@
+package azkv_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mmlt/tool-tmplt/azkv"
+	"github.com/mmlt/tool-tmplt/azkv/azkvtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestList(t *testing.T) {
+	srv := azkvtest.NewServer()
+	defer srv.Close()
+	srv.PageSize = 2
+	team := map[string]string{"team": "foo"}
+	srv.Secrets["app-foo-db"] = []azkvtest.Secret{{Version: "v1", Value: "db", Tags: team}}
+	srv.Secrets["app-foo-api"] = []azkvtest.Secret{{Version: "v1", Value: "api"}}
+	srv.Secrets["app-foo-cache"] = []azkvtest.Secret{{Version: "v1", Value: "cache", Tags: team}}
+	srv.Secrets["app-foo-old"] = []azkvtest.Secret{{Version: "v1", Value: "old", Tags: team, Disabled: true}}
+	srv.Secrets["app-foo-expired"] = []azkvtest.Secret{{Version: "v1", Value: "expired", Expires: time.Now().Add(-time.Hour)}}
+	srv.Secrets["app-foo-future"] = []azkvtest.Secret{{Version: "v1", Value: "future", NotBefore: time.Now().Add(time.Hour)}}
+	srv.Secrets["app-bar-db"] = []azkvtest.Secret{{Version: "v1", Value: "bar", Tags: team}}
+	client := srv.NewClient()
+
+	tests := []struct {
+		it     string
+		filter azkv.Filter
+		want   []string
+	}{
+		{
+			it:     "should select by prefix and skip disabled, expired and not yet valid secrets",
+			filter: azkv.Filter{Prefix: "app-foo-"},
+			want:   []string{"app-foo-api", "app-foo-cache", "app-foo-db"},
+		},
+		{
+			it:     "should select by tag",
+			filter: azkv.Filter{Tag: "team", TagValue: "foo"},
+			want:   []string{"app-bar-db", "app-foo-cache", "app-foo-db"},
+		},
+		{
+			it:     "should select by prefix and tag",
+			filter: azkv.Filter{Prefix: "app-foo-", Tag: "team", TagValue: "foo"},
+			want:   []string{"app-foo-cache", "app-foo-db"},
+		},
+		{
+			it:     "should include invalid secrets when asked",
+			filter: azkv.Filter{Prefix: "app-foo-", All: true},
+			want:   []string{"app-foo-api", "app-foo-cache", "app-foo-db", "app-foo-expired", "app-foo-future", "app-foo-old"},
+		},
+		{
+			it:     "should return nothing when nothing matches",
+			filter: azkv.Filter{Prefix: "nope-"},
+		},
+	}
+	for _, tst := range tests {
+		got, err := azkv.List(tst.filter, client, srv.URL)
+		assert.NoError(t, err, tst.it)
+		assert.Equal(t, tst.want, got, tst.it)
+	}
+	// 7 secrets in pages of 2
+	assert.Equal(t, 4*len(tests), srv.Calls(), "should read all pages")
+}
@@ -0,0 +1,107 @@
+package azkv
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Vaults maps vault aliases to vault urls.
+// The empty alias is the default vault (the one set with -url).
+type Vaults map[string]string
+
+// AliasRE matches valid vault aliases.
+var aliasRE = regexp.MustCompile(`^[0-9a-zA-Z_\-]+$`)
+
+// VersionRE matches Key Vault object versions.
+var versionRE = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// URL returns the url of the vault with 'alias'.
+func (v Vaults) URL(alias string) (string, error) {
+	u, ok := v[alias]
+	if !ok {
+		if alias == "" {
+			return "", fmt.Errorf("no default vault (-url is not set)")
+		}
+		return "", fmt.Errorf("unknown vault alias %s", alias)
+	}
+	return u, nil
+}
+
+// IsAlias returns true when 's' is a (non default) vault alias.
+func (v Vaults) IsAlias(s string) bool {
+	_, ok := v[s]
+	return s != "" && ok
+}
+
+// Resolve returns the vault url, object name and version referred to by the arguments of a template function call
+// like {{secret "name"}}, {{secret "name" "version"}}, {{secret "alias" "name"}} or {{secret "alias" "name" "version"}}.
+// A second argument is a version when it looks like a Key Vault version (32 hex digits) and the first argument is not
+// an alias.
+func (v Vaults) Resolve(args []string) (url, name, version string, err error) {
+	alias := ""
+	switch len(args) {
+	case 1:
+		name = args[0]
+	case 2:
+		if v.IsAlias(args[0]) || !versionRE.MatchString(args[1]) {
+			alias, name = args[0], args[1]
+		} else {
+			name, version = args[0], args[1]
+		}
+	case 3:
+		alias, name, version = args[0], args[1], args[2]
+	default:
+		return "", "", "", fmt.Errorf("expected 1 to 3 arguments, got %d", len(args))
+	}
+	url, err = v.URL(alias)
+	return url, name, version, err
+}
+
+// Validate checks the aliases and urls.
+func (v Vaults) Validate() error {
+	for _, a := range v.aliases() {
+		if a != "" && !aliasRE.MatchString(a) {
+			return fmt.Errorf("vault alias %q should match %s", a, aliasRE)
+		}
+		u, err := url.Parse(v[a])
+		if err != nil {
+			return fmt.Errorf("vault %s: %v", a, err)
+		}
+		if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("vault %s: url %q should be like https://name-of-keyvault.vault.azure.net", a, v[a])
+		}
+	}
+	return nil
+}
+
+// String implements flag.Value.
+func (v Vaults) String() string {
+	var ss []string
+	for _, a := range v.aliases() {
+		ss = append(ss, a+"="+v[a])
+	}
+	return strings.Join(ss, ",")
+}
+
+// Set implements flag.Value, it adds an alias=url pair.
+func (v Vaults) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+		return fmt.Errorf("expected alias=url")
+	}
+	v[kv[0]] = kv[1]
+	return nil
+}
+
+// Aliases returns the sorted aliases.
+func (v Vaults) aliases() []string {
+	var answer []string
+	for a := range v {
+		answer = append(answer, a)
+	}
+	sort.Strings(answer)
+	return answer
+}
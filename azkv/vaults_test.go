@@ -0,0 +1,56 @@
+package azkv_test
+
+import (
+	"testing"
+
+	"github.com/mmlt/tool-tmplt/azkv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVaultsResolve(t *testing.T) {
+	vaults := azkv.Vaults{
+		"":         "https://default.vault.azure.net",
+		"platform": "https://platform.vault.azure.net",
+	}
+	version := "4387e9f3d6e14c459867679a90fd0f79"
+
+	tests := map[string]struct {
+		args               []string
+		url, name, version string
+		wantErr            string
+	}{
+		"Name":             {args: []string{"db"}, url: vaults[""], name: "db"},
+		"NameVersion":      {args: []string{"db", version}, url: vaults[""], name: "db", version: version},
+		"AliasName":        {args: []string{"platform", "db"}, url: vaults["platform"], name: "db"},
+		"AliasNameVersion": {args: []string{"platform", "db", version}, url: vaults["platform"], name: "db", version: version},
+		"UnknownAlias":     {args: []string{"team", "db"}, wantErr: "unknown vault alias team"},
+		"NoArgs":           {args: nil, wantErr: "expected 1 to 3 arguments, got 0"},
+	}
+	for name, tst := range tests {
+		t.Run(name, func(t *testing.T) {
+			url, n, v, err := vaults.Resolve(tst.args)
+			if tst.wantErr != "" {
+				assert.EqualError(t, err, tst.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tst.url, url)
+			assert.Equal(t, tst.name, n)
+			assert.Equal(t, tst.version, v)
+		})
+	}
+
+	_, _, _, err := azkv.Vaults{"platform": vaults["platform"]}.Resolve([]string{"db"})
+	assert.EqualError(t, err, "no default vault (-url is not set)")
+}
+
+func TestVaultsSetValidate(t *testing.T) {
+	vaults := azkv.Vaults{}
+	assert.NoError(t, vaults.Set("platform=https://platform.vault.azure.net"))
+	assert.Error(t, vaults.Set("platform"))
+	assert.NoError(t, vaults.Validate())
+	assert.Equal(t, "platform=https://platform.vault.azure.net", vaults.String())
+
+	assert.Error(t, azkv.Vaults{"plat form": "https://platform.vault.azure.net"}.Validate())
+	assert.Error(t, azkv.Vaults{"platform": "platform.vault.azure.net"}.Validate())
+}
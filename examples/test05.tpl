@@ -7,5 +7,5 @@ Invokation: thycotic -a test05.yaml
 
 {{ range $name, $content := .Files.Glob "*.yaml" }}
 Config: {{ filebase $name }}
-Content: {{ $content | indent 4 }}
+Content: {{ $content | toString | indent 4 }}
 {{ end }}
@@ -9,24 +9,153 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"text/template"
+	"time"
+	"github.com/Azure/azure-sdk-for-go/profiles/latest/keyvault/keyvault"
 	"github.com/mmlt/tool-tmplt/azkv"
 	"github.com/mmlt/tool-tmplt/files"
+	"github.com/mmlt/tool-tmplt/retry"
 	"github.com/mmlt/tool-tmplt/thycotic"
 )
 
+// Options are the settings of Run.
+type Options struct {
+	// Provider is the secret store; thycotic, azkv or empty.
+	Provider string
+	// URL of the secret store.
+	URL string
+	// Username, Password and Domain of the thycotic account.
+	Username, Password, Domain string
+	// Template is the filename of the template to expand.
+	Template string
+	// All is the filename of the yaml file that lists templates and values.
+	All string
+	// SetFile is the filename of a yaml file with values.
+	SetFile string
+	// LockFile is the filename of the file that records the versions of the azkv secrets used.
+	LockFile string
+	// Frozen when true expands azkv secrets with the versions recorded in LockFile.
+	Frozen bool
+	// Vaults are azkv vault urls by alias, they override the vaults in the All file.
+	Vaults azkv.Vaults
+	// AzureAuth is the azkv authentication method, see azkv.AuthMethods.
+	AzureAuth string
+	// AzureCloud is the Azure cloud, see azkv.Cloud.
+	AzureCloud string
+	// AzureAADEndpoint when not empty overrides the Active Directory endpoint of AzureCloud (used by tests).
+	AzureAADEndpoint string
+	// Timeout is the maximum duration of a secret store call attempt, 0 means no timeout.
+	Timeout time.Duration
+	// Retries is the maximum number of retries of a failed secret store call.
+	Retries int
+	// Deadline is the maximum duration of the secret store calls (including retries) of Run, 0 means no deadline.
+	// It's also checked before each template is expanded, it doesn't interrupt the expansion of a template.
+	Deadline time.Duration
+	// FilesRoot is the directory that {{ .Files }} can't escape, empty means the directory of Template or All.
+	FilesRoot string
+	// FilesMaxSize is the maximum total size in bytes of the files read by a {{ .Files.Glob }} or extracted by a
+	// {{ .Files.Archive }}, 0 means no limit.
+	FilesMaxSize int64
+	// Partials is a glob of files with templates ({{ define }}) that can be used in all templates.
+	Partials string
+}
+
 // Run expands one or more templates.
-func Run(provider, url, username, passw, domain, tmplt, all, setFile string, env map[string]string, out io.Writer) error {
+func Run(opts Options, env map[string]string, out io.Writer) error {
+	policy := retry.Policy{Retries: opts.Retries, Timeout: opts.Timeout}
+	if opts.Deadline > 0 {
+		policy.Deadline = time.Now().Add(opts.Deadline)
+	}
+
 	// get override values
-	cliValues, err := readValuesFromYamlFile(setFile)
+	cliValues, err := readValuesFromYamlFile(opts.SetFile)
 	if err != nil {
-		return fmt.Errorf("reading %v: %v", setFile, err)
+		return fmt.Errorf("reading %v: %v", opts.SetFile, err)
+	}
+
+	// get secret versions
+	var lock *azkv.Lock
+	if opts.LockFile != "" {
+		lock, err = azkv.ReadLock(opts.LockFile)
+		if err != nil {
+			return fmt.Errorf("reading %v: %v", opts.LockFile, err)
+		}
+	}
+
+	// get templates to expand
+	var jobs []*job
+	vaults := azkv.Vaults{}
+	errPrefix := "expanding"
+	// render expands {{ tpl }}, {{ .Files.Render }} and {{ include }}, its functions are set when known.
+	render := &renderer{}
+	// fileErrs records the errors of reading files that can't be returned to the template.
+	fileErrs := &files.Errors{}
+	// partials are the globs of the files with shared templates and the directory they are relative to.
+	var partials [][2]string
+	if opts.Partials != "" {
+		partials = append(partials, [2]string{".", opts.Partials})
+	}
+	if opts.Template != "" {
+		root := opts.FilesRoot
+		if root == "" {
+			root = filepath.Dir(opts.Template)
+		}
+		dir := files.Dir{Path: filepath.Dir(opts.Template), Root: root, Renderer: render.render, MaxSize: opts.FilesMaxSize, Errors: fileErrs}
+		jobs = []*job{{file: opts.Template, data: &Template{Values: cliValues, Files: dir}}}
+	} else {
+		bag, err := readConfigFromYamlFile(opts.All)
+		if err != nil {
+			return fmt.Errorf("reading %v: %v", opts.All, err)
+		}
+		root := opts.FilesRoot
+		if root == "" {
+			root = filepath.Dir(opts.All)
+		}
+		jobs = configJobs(bag, cliValues, filepath.Dir(opts.All), files.Dir{Root: root, Renderer: render.render, MaxSize: opts.FilesMaxSize, Errors: fileErrs})
+		for k, v := range bag.Vaults {
+			vaults[k] = v
+		}
+		if bag.Partials != "" {
+			partials = append(partials, [2]string{filepath.Dir(opts.All), bag.Partials})
+		}
+		errPrefix = "expanding " + opts.All
+	}
+
+	// get vaults; cli aliases override config aliases.
+	for k, v := range opts.Vaults {
+		vaults[k] = v
+	}
+	if opts.URL != "" {
+		vaults[""] = opts.URL
+	}
+
+	// get credentials before they are removed from the environment.
+	auth := azkv.Auth{Method: opts.AzureAuth, AADEndpoint: opts.AzureAADEndpoint, Env: make(map[string]string)}
+	for k, v := range env {
+		auth.Env[k] = v
+	}
+
+	// vault names are expanded to urls in the selected cloud.
+	if opts.Provider == "azkv" {
+		auth.Cloud, err = azkv.Cloud(opts.AzureCloud, auth.Env)
+		if err != nil {
+			return err
+		}
+		if err := vaults.Expand(auth.Cloud); err != nil {
+			return err
+		}
+		if err := vaults.Validate(); err != nil {
+			return err
+		}
 	}
 
 	// remove sensitive data from OS environment
-	env = sanitizeValue(env, passw)
+	env = sanitizeValue(env, opts.Password)
 	env = sanitizeKey(env, "AZURE_.*")
 
 	// get template functions
@@ -34,29 +163,72 @@ func Run(provider, url, username, passw, domain, tmplt, all, setFile string, env
 	// override sprig function to make sure a sanitized environment is used.
 	functions["env"] = func(s string) string { return env[s] }
 	functions["expandenv"] = func(s string) string { return "<expandenv is not supported>" }
-	functions = addSecretFunction(functions, provider, url, username, passw, domain)
+	functions = addSecretFunction(functions, opts, policy, auth, vaults, lock)
+	// add function to handle {{ tpl "text" . }} calls.
+	functions["tpl"] = func(text string, data interface{}) (string, error) {
+		return render.render("tpl", text, data)
+	}
+	// add function to handle {{ include "name" . }} calls, it's replaced per template by renderer.parse.
+	functions["include"] = func(name string, data interface{}) (string, error) {
+		return "", fmt.Errorf("include: not supported")
+	}
+	render.functions = functions
+
+	// parse shared templates.
+	for _, p := range partials {
+		if err := render.addPartials(p[0], p[1]); err != nil {
+			return fmt.Errorf("%s: partials: %v", errPrefix, err)
+		}
+	}
 
-	if tmplt != "" {
-		// expand template
-		err := expand(tmplt, functions, &Template{Values: cliValues, Files: files.Dir(filepath.Dir(tmplt))}, out)
+	// parse and check all templates before expanding any.
+	for _, j := range jobs {
+		j.tmpl, err = parseFile(j.file, render)
 		if err != nil {
-			return fmt.Errorf("expanding: %v", err)
+			return fmt.Errorf("%s: %v", errPrefix, err)
 		}
-	} else {
-		bag, err := readConfigFromYamlFile(all)
+		if opts.Provider == "azkv" {
+			if err := checkKvCalls(j.tmpl, vaults); err != nil {
+				return fmt.Errorf("%s: %v", errPrefix, err)
+			}
+		}
+	}
+
+	// expand...
+	for _, j := range jobs {
+		if !policy.Deadline.IsZero() && time.Now().After(policy.Deadline) {
+			return fmt.Errorf("%s: deadline of %v exceeded", errPrefix, opts.Deadline)
+		}
+		err := j.tmpl.Execute(out, j.data)
 		if err != nil {
-			return fmt.Errorf("reading %v: %v", all, err)
+			return fmt.Errorf("%s: %v", errPrefix, err)
 		}
+		if err := fileErrs.Err(); err != nil {
+			return fmt.Errorf("%s: %s: %v", errPrefix, j.tmpl.Name(), err)
+		}
+	}
 
-		err = expandAll(bag, functions, cliValues, filepath.Dir(all), out)
+	// record secret versions
+	if lock != nil && !opts.Frozen {
+		err = lock.Write(opts.LockFile)
 		if err != nil {
-			return fmt.Errorf("expanding %s: %v", all, err)
+			return fmt.Errorf("writing %v: %v", opts.LockFile, err)
 		}
 	}
 	return nil
 }
 
-func expandAll(bag *Config, functions template.FuncMap, cliValues Values, basePath string, out io.Writer) error {
+// Job is a template file and the data to expand it with.
+type job struct {
+	file string
+	data *Template
+	tmpl *template.Template
+}
+
+// ConfigJobs returns the templates listed in a Config with their values.
+// Template files are relative to basePath, their {{ .Files }} are 'dir' with the Path of the template.
+func configJobs(bag *Config, cliValues Values, basePath string, dir files.Dir) []*job {
+	var jobs []*job
 	for _, t := range bag.Templates {
 		// get generic values
 		v := deepCopy(bag.Values)
@@ -65,61 +237,241 @@ func expandAll(bag *Config, functions template.FuncMap, cliValues Values, basePa
 		// and merge cli provided values
 		merge(cliValues, v)
 		f := filepath.Join(basePath, t.File)
-		err := expand(f, functions, &Template{Values: v, Files: files.Dir(filepath.Dir(f))}, out)
-		if err != nil {
-			return err
-			//glog.Exitf("expanding %s: %v", *all, err)
-		}
+		dir.Path = filepath.Dir(f)
+		jobs = append(jobs, &job{file: f, data: &Template{Values: v, Files: dir}})
 	}
-	return nil
+	return jobs
 }
 
-// Expand 'filename' template and write result to 'out'.
-func expand(filename string, functions template.FuncMap, data interface{}, out io.Writer) error {
+// ParseFile reads 'filename' and returns it as template.
+// The template includes the partials of 'render'.
+func parseFile(filename string, render *renderer) (*template.Template, error) {
 	// read template file
 	b, err := ioutil.ReadFile(filename)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	in := string(b)
 
 	// Create a template, add the function map, and parse the text.
-	tmpl, err := template.New("input").Funcs(functions).Parse(in)
+	tmpl, err := render.parse(filepath.Base(filename), in)
 	if err != nil {
-		glog.Exitf("parsing of '%s' failed: %s", filename, err)
+		return nil, fmt.Errorf("parsing of '%s' failed: %s", filename, err)
 	}
-	// expand...
-	return tmpl.Execute(out, data)
+	return tmpl, nil
 }
 
-// AddSecretFunction
-func addSecretFunction(functions template.FuncMap, provider, url, username, passw, domain string) template.FuncMap {
-	switch provider {
+// AddSecretFunction adds the template function(s) of the selected secret provider.
+// Policy controls the retries of secret store calls, auth are the azkv credentials, vaults are the azkv vaults by
+// alias, lock (if not nil) records the azkv secret versions used.
+func addSecretFunction(functions template.FuncMap, opts Options, policy retry.Policy, auth azkv.Auth, vaults azkv.Vaults, lock *azkv.Lock) template.FuncMap {
+	url := opts.URL
+	switch opts.Provider {
 	case "thycotic":
-		client, token, err := thycotic.Login(url, username, passw, domain)
+		client, token, err := thycotic.Login(url, opts.Username, opts.Password, opts.Domain, policy)
 		if err != nil {
 			glog.Exitf("thycotic: login failed: %v", err)
 		}
 		// add function to handle {{thycotic 1234 "Fieldnane"}} calls.
-		functions["thycotic"] = func(id int, item string) string {
-			s, err := thycotic.Get(int32(id), item, client, token)
+		// An optional third argument selects a historical value; {{thycotic 1234 "Fieldname" "2019-11-01T12:00:00Z"}}
+		// returns the value at that time and {{thycotic 1234 "Fieldname" 1}} returns the value before the current one.
+		functions["thycotic"] = func(id int, item string, version ...interface{}) (string, error) {
+			var s string
+			var err error
+			switch {
+			case len(version) == 0:
+				s, err = thycotic.Get(int32(id), item, client, token)
+			case len(version) > 1:
+				return "", fmt.Errorf("thycotic: expected at most 3 arguments")
+			default:
+				// any integer kind is accepted as sprig arithmetic (add, sub) returns int64.
+				switch v := reflect.ValueOf(version[0]); v.Kind() {
+				case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+					s, err = thycotic.GetVersion(int32(id), item, int(v.Int()), client, token)
+				case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+					s, err = thycotic.GetVersion(int32(id), item, int(v.Uint()), client, token)
+				case reflect.String:
+					var at time.Time
+					at, err = thycotic.ParseTime(v.String())
+					if err != nil {
+						return "", fmt.Errorf("thycotic: %v", err)
+					}
+					s, err = thycotic.GetAt(int32(id), item, at, client, token)
+				default:
+					return "", fmt.Errorf("thycotic: version should be a time or history index, got %T", version[0])
+				}
+			}
 			if err != nil {
+				if len(version) > 0 {
+					// a placeholder would silently end up in a rollback.
+					return "", fmt.Errorf("thycotic: %v", err)
+				}
 				glog.Errorf("thycotic: %v", err)
 			}
-			return s
+			return s, nil
+		}
+		// add function to handle {{thycoticSSH 1234 "host.example.com"}} calls.
+		// A failure stops the expansion; the fields of a missing map would silently expand to empty strings.
+		functions["thycoticSSH"] = func(id int, machine ...string) (map[string]string, error) {
+			if len(machine) > 1 {
+				return nil, fmt.Errorf("thycoticSSH: expected at most 2 arguments, got %d", len(machine)+1)
+			}
+			m, err := thycotic.GetSSH(int32(id), strings.Join(machine, ""), client, token)
+			if err != nil {
+				return nil, fmt.Errorf("thycoticSSH: %v", err)
+			}
+			return m, nil
 		}
 	case "azkv":
-		client, err := azkv.Login(url)
-		if err != nil {
-			glog.Exitf("azure key vault: login failed: %v", err)
+		// one client (and its credentials) is used for all vaults.
+		// login happens on first use so templates are checked before credentials are.
+		// a failed login is returned by each function so the expansion stops with the position of the call.
+		var client *keyvault.BaseClient
+		var loginErr error
+		var once sync.Once
+		login := func() (*keyvault.BaseClient, error) {
+			once.Do(func() {
+				client, loginErr = azkv.Login(auth, policy)
+				if loginErr != nil {
+					loginErr = fmt.Errorf("azure key vault: login failed: %v", loginErr)
+				}
+			})
+			return client, loginErr
+		}
+		// add function to handle {{secret "name-of-secret"}}, {{secret "name-of-secret" "version"}},
+		// {{secret "alias" "name-of-secret"}} and {{secret "alias" "name-of-secret" "version"}} calls.
+		functions["secret"] = func(args ...string) (string, error) {
+			c, err := parseKvCall("secret", args, vaults)
+			if err != nil {
+				return "", err
+			}
+			kv, err := login()
+			if err != nil {
+				return "", err
+			}
+			s, err := azkv.GetLocked(c.name, c.version, kv, c.url, lock, opts.Frozen)
+			if err != nil {
+				if opts.Frozen {
+					// a frozen expansion should never silently use another value.
+					return "", fmt.Errorf("secret: %v", err)
+				}
+				glog.Errorf("azure key vault: %v", err)
+			}
+			return s, nil
+		}
+		// add function to handle {{kvSecretDecoded "name-of-secret"}} calls, the arguments are the same as secret.
+		// The value is decoded according to its content type, any failure stops the expansion.
+		functions["kvSecretDecoded"] = func(args ...string) (interface{}, error) {
+			c, err := parseKvCall("kvSecretDecoded", args, vaults)
+			if err != nil {
+				return nil, err
+			}
+			kv, err := login()
+			if err != nil {
+				return nil, err
+			}
+			v, err := azkv.GetDecoded(c.name, c.version, kv, c.url, lock, opts.Frozen)
+			if err != nil {
+				return nil, fmt.Errorf("kvSecretDecoded: %v", err)
+			}
+			return v, nil
+		}
+		// listSecrets returns the values of the secrets selected by c.filter by name.
+		listSecrets := func(fn string, c kvCall) (map[string]string, error) {
+			kv, err := login()
+			if err != nil {
+				return nil, err
+			}
+			names, err := azkv.List(c.filter, kv, c.url)
+			if err != nil {
+				// an empty map would silently expand to a Secret without entries.
+				return nil, fmt.Errorf("%s: %v", fn, err)
+			}
+			m := make(map[string]string, len(names))
+			for _, n := range names {
+				s, err := azkv.GetLocked(n, "", kv, c.url, lock, opts.Frozen)
+				if err != nil {
+					if opts.Frozen {
+						return nil, fmt.Errorf("%s: %v", fn, err)
+					}
+					glog.Errorf("azure key vault: %v", err)
+				}
+				m[n] = s
+			}
+			return m, nil
+		}
+		// add function to handle {{kvSecrets "prefix"}} and {{kvSecrets "alias" "prefix"}} calls.
+		functions["kvSecrets"] = func(args ...string) (map[string]string, error) {
+			c, err := parseKvCall("kvSecrets", args, vaults)
+			if err != nil {
+				return nil, err
+			}
+			return listSecrets("kvSecrets", c)
 		}
-		// add function to handle {{secret "name-of-secret"}} calls.
-		functions["secret"] = func(id string) string {
-			s, err := azkv.Get(id, client, url)
+		// add function to handle {{kvSecretsByTag "tag" "value"}} and {{kvSecretsByTag "alias" "tag" "value"}} calls.
+		functions["kvSecretsByTag"] = func(args ...string) (map[string]string, error) {
+			c, err := parseKvCall("kvSecretsByTag", args, vaults)
 			if err != nil {
+				return nil, err
+			}
+			return listSecrets("kvSecretsByTag", c)
+		}
+		// add function to handle {{kvCertificate "name-of-certificate"}} calls.
+		functions["kvCertificate"] = func(args ...string) (string, error) {
+			c, err := parseKvCall("kvCertificate", args, vaults)
+			if err != nil {
+				return "", err
+			}
+			kv, err := login()
+			if err != nil {
+				return "", err
+			}
+			s, err := azkv.GetCertificate(c.name, kv, c.url, lock, opts.Frozen)
+			if err != nil {
+				if opts.Frozen {
+					return "", fmt.Errorf("kvCertificate: %v", err)
+				}
+				glog.Errorf("azure key vault: %v", err)
+			}
+			return s, nil
+		}
+		// add function to handle {{kvCertificateBundle "name-of-certificate"}} calls.
+		functions["kvCertificateBundle"] = func(args ...string) (string, error) {
+			c, err := parseKvCall("kvCertificateBundle", args, vaults)
+			if err != nil {
+				return "", err
+			}
+			kv, err := login()
+			if err != nil {
+				return "", err
+			}
+			s, err := azkv.GetCertificateBundle(c.name, kv, c.url, lock, opts.Frozen)
+			if err != nil {
+				if opts.Frozen {
+					return "", fmt.Errorf("kvCertificateBundle: %v", err)
+				}
+				glog.Errorf("azure key vault: %v", err)
+			}
+			return s, nil
+		}
+		// add function to handle {{kvKey "name-of-key"}} and {{kvKey "name-of-key" "jwk"}} calls.
+		functions["kvKey"] = func(args ...string) (string, error) {
+			c, err := parseKvCall("kvKey", args, vaults)
+			if err != nil {
+				return "", err
+			}
+			kv, err := login()
+			if err != nil {
+				return "", err
+			}
+			s, err := azkv.GetKey(c.name, c.format, kv, c.url, lock, opts.Frozen)
+			if err != nil {
+				if opts.Frozen {
+					return "", fmt.Errorf("kvKey: %v", err)
+				}
 				glog.Errorf("azure key vault: %v", err)
 			}
-			return s
+			return s, nil
 		}
 	}
 	return functions
@@ -139,10 +491,28 @@ func getDefaultFunctions() template.FuncMap {
 	answer["filedir"] = filepath.Dir
 	answer["fileclean"] = filepath.Clean
 	answer["fileext"] = filepath.Ext
+	// keys is sorted and also takes maps like .Files.Glob
+	answer["keys"] = sortedKeys
 
 	return answer
 }
 
+// SortedKeys returns the sorted keys of one or more maps with string keys.
+func sortedKeys(maps ...interface{}) ([]string, error) {
+	k := []string{}
+	for _, m := range maps {
+		v := reflect.ValueOf(m)
+		if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("keys: expected a map with string keys, got %T", m)
+		}
+		for _, key := range v.MapKeys() {
+			k = append(k, key.String())
+		}
+	}
+	sort.Strings(k)
+	return k, nil
+}
+
 // ReadValuesFromYamlFile returns the contents of 'file' in 'Values' structure.
 func readValuesFromYamlFile(file string) (Values, error) {
 	answer := make(Values)
@@ -186,9 +556,13 @@ type Values map[interface{}]interface{}
 
 // Config is the file format of the yaml file used in combination with the -a flag.
 // Values are 'global' values that are overridden by Template.Values.
+// Vaults are the azkv vault urls by alias.
+// Partials is a glob (relative to the Config file) of files with templates that can be used in all templates.
 type Config struct {
 	Templates []TemplateConfig `yaml:"templates"`
 	Values    Values           `yaml:"values"`
+	Partials  string           `yaml:"partials"`
+	Vaults    azkv.Vaults      `yaml:"vaults"`
 }
 
 // TemplateConfig is the per-file entry as read from the Config.
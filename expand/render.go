@@ -0,0 +1,135 @@
+package expand
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/mmlt/tool-tmplt/files"
+)
+
+// MaxRenderDepth is the maximum nesting of tpl, Files.Render and include calls.
+const maxRenderDepth = 10
+
+// Renderer parses and expands templates with the template functions and the partials.
+type renderer struct {
+	functions template.FuncMap
+	// partials are the parse trees of the templates defined in partial files by name.
+	partials map[string]*parse.Tree
+	// partialFiles are the partial files by defined template name.
+	partialFiles map[string]string
+	// loaded are the partial files that have been parsed.
+	loaded map[string]bool
+	// depth is the current nesting of render and include calls.
+	depth int
+}
+
+// AddPartials parses the files matching glob 'pattern' in 'dir' and adds the templates they define to the partials.
+// An error is returned when a template is defined more than once.
+// An absolute pattern isn't relative to 'dir'.
+func (r *renderer) addPartials(dir, pattern string) error {
+	if filepath.IsAbs(pattern) {
+		vol := filepath.VolumeName(pattern)
+		dir = vol + string(filepath.Separator)
+		pattern = filepath.ToSlash(pattern[len(vol):])
+	}
+	fs, err := files.Dir{Path: dir}.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	if r.partials == nil {
+		r.partials = map[string]*parse.Tree{}
+		r.partialFiles = map[string]string{}
+		r.loaded = map[string]bool{}
+	}
+	for _, n := range fs.Names() {
+		name := filepath.Join(dir, filepath.FromSlash(n))
+		abs, err := filepath.Abs(name)
+		if err != nil {
+			return err
+		}
+		if r.loaded[abs] {
+			// matched by more than one glob.
+			continue
+		}
+		r.loaded[abs] = true
+		text, err := fs.Get(n)
+		if err != nil {
+			return err
+		}
+		tmpl, err := template.New(filepath.Base(name)).Funcs(r.functions).Parse(text)
+		if err != nil {
+			return fmt.Errorf("parsing of '%s' failed: %s", name, err)
+		}
+		for _, t := range tmpl.Templates() {
+			n := t.Name()
+			if n == tmpl.Name() {
+				// text outside of define blocks is ignored.
+				continue
+			}
+			if other, ok := r.partialFiles[n]; ok {
+				return fmt.Errorf("template %q is defined in partial %s and %s", n, other, name)
+			}
+			r.partials[n] = t.Tree
+			r.partialFiles[n] = name
+		}
+	}
+	return nil
+}
+
+// Parse returns template 'text' named 'name' with the partials added.
+// An error is returned when 'text' defines a template that is also defined in a partial.
+func (r *renderer) parse(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(r.functions).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tmpl.Templates() {
+		if other, ok := r.partialFiles[t.Name()]; ok && t.Name() != name {
+			return nil, fmt.Errorf("template %q is defined in %s and partial %s", t.Name(), name, other)
+		}
+	}
+	for n, tree := range r.partials {
+		if _, err := tmpl.AddParseTree(n, tree); err != nil {
+			return nil, err
+		}
+	}
+	// include executes a template of this set and returns the result so it can be piped.
+	tmpl.Funcs(template.FuncMap{
+		"include": func(n string, data interface{}) (string, error) {
+			return r.execute(tmpl, n, data)
+		},
+	})
+	return tmpl, nil
+}
+
+// Render expands template 'text' named 'name' with 'data'.
+// Errors include 'name' so the nested file can be found.
+func (r *renderer) render(name, text string, data interface{}) (string, error) {
+	if r.depth >= maxRenderDepth {
+		return "", fmt.Errorf("%s: maximum nesting depth of %d exceeded", name, maxRenderDepth)
+	}
+	tmpl, err := r.parse(name, text)
+	if err != nil {
+		return "", err
+	}
+	return r.execute(tmpl, name, data)
+}
+
+// Execute expands the template 'name' of set 'tmpl' with 'data'.
+func (r *renderer) execute(tmpl *template.Template, name string, data interface{}) (string, error) {
+	if r.depth >= maxRenderDepth {
+		return "", fmt.Errorf("%s: maximum nesting depth of %d exceeded", name, maxRenderDepth)
+	}
+	r.depth++
+	defer func() { r.depth-- }()
+
+	var b strings.Builder
+	err := tmpl.ExecuteTemplate(&b, name, data)
+	if err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
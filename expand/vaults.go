@@ -0,0 +1,172 @@
+package expand
+
+import (
+	"fmt"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/mmlt/tool-tmplt/azkv"
+)
+
+// KvFunctions are the names of the template functions that take vault (alias) arguments.
+var kvFunctions = map[string]bool{
+	"secret":              true,
+	"kvCertificate":       true,
+	"kvCertificateBundle": true,
+	"kvKey":               true,
+	"kvSecrets":           true,
+	"kvSecretsByTag":      true,
+	"kvSecretDecoded":     true,
+}
+
+// KvCall are the parsed arguments of an azkv template function call.
+type kvCall struct {
+	url, name, version, format string
+	// filter selects the secrets of kvSecrets and kvSecretsByTag.
+	filter azkv.Filter
+}
+
+// ParseKvCall parses the arguments of azkv template function 'fn'.
+// See azkv.Vaults.Resolve for the forms accepted, kvKey also accepts a trailing "pem" or "jwk" format argument.
+// kvSecrets and kvSecretsByTag take an optional alias followed by a prefix or a tag and value.
+func parseKvCall(fn string, args []string, vaults azkv.Vaults) (kvCall, error) {
+	c := kvCall{}
+	switch fn {
+	case "kvSecrets", "kvSecretsByTag":
+		n := 1
+		if fn == "kvSecretsByTag" {
+			n = 2
+		}
+		if len(args) != n && len(args) != n+1 {
+			return c, fmt.Errorf("%s: expected %d or %d arguments, got %d", fn, n, n+1, len(args))
+		}
+		alias := ""
+		if len(args) > n {
+			alias, args = args[0], args[1:]
+		}
+		var err error
+		c.url, err = vaults.URL(alias)
+		if err != nil {
+			return c, fmt.Errorf("%s: %v", fn, err)
+		}
+		if fn == "kvSecrets" {
+			c.filter.Prefix = args[0]
+		} else {
+			c.filter.Tag, c.filter.TagValue = args[0], args[1]
+		}
+		return c, nil
+	}
+	if fn == "kvKey" && len(args) > 1 && (args[len(args)-1] == "pem" || args[len(args)-1] == "jwk") {
+		c.format = args[len(args)-1]
+		args = args[:len(args)-1]
+	}
+	var err error
+	c.url, c.name, c.version, err = vaults.Resolve(args)
+	if err != nil {
+		return c, fmt.Errorf("%s: %v", fn, err)
+	}
+	if c.version != "" && fn != "secret" && fn != "kvSecretDecoded" {
+		return c, fmt.Errorf("%s: a version argument is not supported", fn)
+	}
+	return c, nil
+}
+
+// CheckKvCalls returns an error when 'tmpl' contains an azkv template function call with literal arguments that
+// refer to an unknown vault alias.
+func checkKvCalls(tmpl *template.Template, vaults azkv.Vaults) error {
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		c := &kvChecker{tree: t.Tree, vaults: vaults}
+		if err := c.walk(t.Tree.Root); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// KvChecker walks a parse tree to check azkv template function calls.
+type kvChecker struct {
+	tree   *parse.Tree
+	vaults azkv.Vaults
+}
+
+// Walk checks 'node' and its children.
+func (c *kvChecker) walk(node parse.Node) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, x := range n.Nodes {
+			if err := c.walk(x); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		return c.walk(n.Pipe)
+	case *parse.IfNode:
+		return c.walkBranch(&n.BranchNode)
+	case *parse.RangeNode:
+		return c.walkBranch(&n.BranchNode)
+	case *parse.WithNode:
+		return c.walkBranch(&n.BranchNode)
+	case *parse.TemplateNode:
+		return c.walk(n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		for i, cmd := range n.Cmds {
+			// commands after the first get the piped value as extra argument so they can't be checked.
+			if i == 0 {
+				if err := c.check(cmd); err != nil {
+					return err
+				}
+			}
+			for _, a := range cmd.Args {
+				if err := c.walk(a); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// WalkBranch checks the pipe and lists of an if, range or with node.
+func (c *kvChecker) walkBranch(n *parse.BranchNode) error {
+	if err := c.walk(n.Pipe); err != nil {
+		return err
+	}
+	if err := c.walk(n.List); err != nil {
+		return err
+	}
+	return c.walk(n.ElseList)
+}
+
+// Check checks a command with only literal string arguments.
+func (c *kvChecker) check(cmd *parse.CommandNode) error {
+	if len(cmd.Args) == 0 {
+		return nil
+	}
+	id, ok := cmd.Args[0].(*parse.IdentifierNode)
+	if !ok || !kvFunctions[id.Ident] {
+		return nil
+	}
+	var args []string
+	for _, a := range cmd.Args[1:] {
+		s, ok := a.(*parse.StringNode)
+		if !ok {
+			return nil
+		}
+		args = append(args, s.Text)
+	}
+	_, err := parseKvCall(id.Ident, args, c.vaults)
+	if err != nil {
+		location, _ := c.tree.ErrorContext(cmd)
+		return fmt.Errorf("%s: %v", location, err)
+	}
+	return nil
+}
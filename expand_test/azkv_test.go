@@ -0,0 +1,206 @@
+// test templates that use values from Azure Key Vault.
+package expand_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/mmlt/tool-tmplt/azkv/azkvtest"
+	"github.com/mmlt/tool-tmplt/expand"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestVaultAliases tests if unknown vault aliases are rejected before any template is expanded.
+func TestVaultAliases(t *testing.T) {
+	tf := testFilesNew()
+	defer tf.MustRemoveAll()
+	// create file(s)
+	tf.MustCreate("tpl/first.txt", `first`)
+	tf.MustCreate("tpl/second.txt", `
+{{ secret "platform" "db-password" }}
+{{ if true }}{{ kvCertificate "team" "tls" }}{{ end }}`)
+	tf.MustCreate("all.yaml", `
+templates:
+- file: tpl/first.txt
+- file: tpl/second.txt
+vaults:
+  platform: https://platform.vault.azure.net`)
+	// expand
+	var out bytes.Buffer
+	opts := expand.Options{
+		Provider: "azkv",
+		URL:      "https://default.vault.azure.net",
+		All:      tf.Path("all.yaml"),
+	}
+	err := expand.Run(opts, nil, &out)
+	// assert
+	assert.EqualError(t, err, "expanding "+tf.Path("all.yaml")+": second.txt:3:16: kvCertificate: unknown vault alias team")
+	assert.Empty(t, out.String())
+
+	// aliases set on the cli are known too.
+	opts.Vaults = map[string]string{"platform": "http://127.0.0.1:1", "team": "https://team.vault.azure.net"}
+	tf.MustCreate("tpl/second.txt", `{{ kvCertificate "team" "tls" }}{{ secret "nosuchalias" "db-password" "4387e9f3d6e14c459867679a90fd0f79" }}`)
+	err = expand.Run(opts, nil, &out)
+	assert.EqualError(t, err, "expanding "+tf.Path("all.yaml")+": second.txt:1:35: secret: unknown vault alias nosuchalias")
+
+	// list functions take an optional alias.
+	tf.MustCreate("tpl/second.txt", `{{ range kvSecrets "team" "app-" }}{{ end }}{{ kvSecretsByTag "nosuchalias" "team" "foo" }}`)
+	err = expand.Run(opts, nil, &out)
+	assert.EqualError(t, err, "expanding "+tf.Path("all.yaml")+": second.txt:1:47: kvSecretsByTag: unknown vault alias nosuchalias")
+}
+
+// TestVaultCloud tests if vault urls that are not in the selected cloud are rejected before any template is expanded.
+func TestVaultCloud(t *testing.T) {
+	tf := testFilesNew()
+	defer tf.MustRemoveAll()
+	// create file(s)
+	tf.MustCreate("tpl/first.txt", `{{ secret "platform" "db-password" }}`)
+	tf.MustCreate("all.yaml", `
+templates:
+- file: tpl/first.txt
+vaults:
+  platform: https://platform.vault.azure.net`)
+	// expand
+	var out bytes.Buffer
+	opts := expand.Options{
+		Provider:   "azkv",
+		URL:        "default-kv",
+		AzureCloud: "usgovernment",
+		All:        tf.Path("all.yaml"),
+	}
+	err := expand.Run(opts, nil, &out)
+	// assert
+	assert.EqualError(t, err, `vault platform: url "https://platform.vault.azure.net" is in AzurePublicCloud, not in AzureUSGovernmentCloud`)
+	assert.Empty(t, out.String())
+}
+
+// TestLockFile tests if the secret versions used are recorded in the lock file and if a frozen expansion uses them.
+func TestLockFile(t *testing.T) {
+	srv := azkvtest.NewServer()
+	defer srv.Close()
+	srv.Token = "t0k3n"
+	srv.ClientSecret = "s3cret"
+	srv.Secrets["db-password"] = []azkvtest.Secret{{Version: "1", Value: "first-secret"}}
+
+	tf := testFilesNew()
+	defer tf.MustRemoveAll()
+	// create file(s)
+	tf.MustCreate("tpl/example.txt", `{{ secret "db-password" }}`)
+	// Run sanitizes the environment it's passed.
+	env := func() map[string]string {
+		return map[string]string{"AZURE_TENANT_ID": "my-tenant", "AZURE_CLIENT_ID": "my-client", "AZURE_CLIENT_SECRET": "s3cret"}
+	}
+	// expand
+	var out bytes.Buffer
+	opts := expand.Options{
+		Provider:         "azkv",
+		URL:              srv.URL,
+		AzureAADEndpoint: srv.URL + "/",
+		Template:         tf.Path("tpl/example.txt"),
+		LockFile:         tf.Path("tmplt.lock"),
+	}
+	err := expand.Run(opts, env(), &out)
+	assert.NoError(t, err)
+	assert.Equal(t, "first-secret", out.String())
+	b, err := ioutil.ReadFile(tf.Path("tmplt.lock"))
+	assert.NoError(t, err)
+	assert.Equal(t, "secrets:\n  "+srv.URL+":\n    db-password: \"1\"\n", string(b))
+
+	// a frozen expansion uses the locked version after the secret is rotated.
+	srv.Secrets["db-password"] = append(srv.Secrets["db-password"], azkvtest.Secret{Version: "2", Value: "rotated-secret"})
+	opts.Frozen = true
+	out.Reset()
+	err = expand.Run(opts, env(), &out)
+	assert.NoError(t, err)
+	assert.Equal(t, "first-secret", out.String())
+
+	// a frozen expansion fails on a secret that isn't locked.
+	tf.MustCreate("tpl/example.txt", `{{ secret "api-key" }}`)
+	out.Reset()
+	err = expand.Run(opts, env(), &out)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "secret: secret api-key is not locked")
+	}
+
+	// an expansion that isn't frozen uses and records the latest version.
+	tf.MustCreate("tpl/example.txt", `{{ secret "db-password" }}`)
+	opts.Frozen = false
+	out.Reset()
+	err = expand.Run(opts, env(), &out)
+	assert.NoError(t, err)
+	assert.Equal(t, "rotated-secret", out.String())
+	b, err = ioutil.ReadFile(tf.Path("tmplt.lock"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `db-password: "2"`)
+}
+
+// TestLoginFailure tests if a failed login stops the expansion with the position of the first secret.
+func TestLoginFailure(t *testing.T) {
+	srv := azkvtest.NewServer()
+	defer srv.Close()
+	srv.Token = "t0k3n"
+	srv.ClientSecret = "s3cret"
+
+	tf := testFilesNew()
+	defer tf.MustRemoveAll()
+	// create file(s)
+	tf.MustCreate("tpl/example.txt", `first {{ secret "db-password" }}`)
+	env := map[string]string{"AZURE_TENANT_ID": "my-tenant", "AZURE_CLIENT_ID": "my-client", "AZURE_CLIENT_SECRET": "wrong"}
+	// expand
+	var out bytes.Buffer
+	opts := expand.Options{
+		Provider:         "azkv",
+		URL:              srv.URL,
+		AzureAADEndpoint: srv.URL + "/",
+		Template:         tf.Path("tpl/example.txt"),
+		LockFile:         tf.Path("tmplt.lock"),
+	}
+	err := expand.Run(opts, env, &out)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `expanding: template: example.txt:1:9: executing "example.txt" at <secret "db-password">: error calling secret: azure key vault: login failed: `)
+	}
+	assert.Equal(t, "first ", out.String())
+	// the lock file isn't written.
+	_, err = os.Stat(tf.Path("tmplt.lock"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+// TestListFailure tests if a failure to list secrets stops the expansion.
+func TestListFailure(t *testing.T) {
+	srv := azkvtest.NewServer()
+	defer srv.Close()
+	srv.Token = "t0k3n"
+	srv.ClientSecret = "s3cret"
+	srv.Secrets["app-db"] = []azkvtest.Secret{{Version: "1", Value: "db-secret"}}
+
+	tf := testFilesNew()
+	defer tf.MustRemoveAll()
+	// create file(s)
+	tf.MustCreate("tpl/example.txt", `{{ range $name, $value := kvSecrets "app-" }}{{ $name }}={{ $value }}{{ end }}`)
+	env := func() map[string]string {
+		return map[string]string{"AZURE_TENANT_ID": "my-tenant", "AZURE_CLIENT_ID": "my-client", "AZURE_CLIENT_SECRET": "s3cret"}
+	}
+	// expand
+	var out bytes.Buffer
+	opts := expand.Options{
+		Provider:         "azkv",
+		URL:              srv.URL,
+		AzureAADEndpoint: srv.URL + "/",
+		Template:         tf.Path("tpl/example.txt"),
+	}
+	err := expand.Run(opts, env(), &out)
+	assert.NoError(t, err)
+	assert.Equal(t, "app-db=db-secret", out.String())
+
+	// the token request succeeds, the list request fails.
+	srv.Failures = []int{0, http.StatusForbidden}
+	out.Reset()
+	err = expand.Run(opts, env(), &out)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "error calling kvSecrets: kvSecrets: ")
+	}
+	assert.Equal(t, "", out.String())
+}
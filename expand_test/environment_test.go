@@ -18,7 +18,7 @@ User is {{ env "TESTUSER" }}`)
 	env := map[string]string{"TESTUSER": "Pipo"}
 	// expand
 	var out bytes.Buffer
-	err := expand.Run("", "", "", "", tf.Path("tpl/example.yaml"), "", "", env, &out)
+	err := expand.Run(expand.Options{Template: tf.Path("tpl/example.yaml")}, env, &out)
 	assert.NoError(t, err)
 	// assert
 	assert.Equal(t, []byte(`
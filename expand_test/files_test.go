@@ -0,0 +1,233 @@
+// test templates that use .Files.
+package expand_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mmlt/tool-tmplt/expand"
+	"github.com/stretchr/testify/assert"
+)
+
+var filesTests = map[string]struct {
+	// files by path
+	files map[string]string
+	// template
+	ttext string
+	want  string
+}{
+	// Lines ranges over the lines of a file.
+	"Lines": {
+		files: map[string]string{"hosts.txt": "# hosts\n10.0.0.1\n\n10.0.0.2\n"},
+		ttext: `{{ range .Files.Head "hosts.txt" 5 }}- {{ . }}
+{{ end }}`,
+		want: `- 10.0.0.1
+- 10.0.0.2
+`,
+	},
+
+	// Binary files are passed unaltered.
+	"Binary": {
+		files: map[string]string{"bin/blob": "\x1f\x8b\xff\x00"},
+		ttext: `{{ .Files.GetBytes "bin/blob" | toString | b64enc }} {{ range $name, $content := .Files.Glob "bin/*" }}{{ $content | toString | b64enc }}{{ end }}`,
+		want:  `H4v/AA== H4v/AA==`,
+	},
+
+	// Glob matches recursively and in sorted order.
+	"Glob": {
+		files: map[string]string{"conf/b.conf": "b", "conf/x/a.conf": "a", "conf/x/test.conf": "t", "conf/c.txt": "c"},
+		ttext: `{{ range $name, $content := .Files.Glob "conf/**/*.conf" "!**/test.*" }}{{ filebase $name }}={{ $content | toString }} {{ end }}`,
+		want:  `b.conf=b a.conf=a `,
+	},
+
+	// Range values are files, toString makes them usable by text functions.
+	"RangeIndent": {
+		files: map[string]string{"conf/app.conf": "a: 1\nb: 2\n"},
+		ttext: `{{ range $name, $content := .Files.Glob "conf/*" }}{{ $name }}: |
+{{ $content | toString | indent 2 }}{{ end }}`,
+		// indent also indents after the final line ending.
+		want: "conf/app.conf: |\n  a: 1\n  b: 2\n  ",
+	},
+
+	// Glob keys are relative to the template directory, slash separated and sorted.
+	"GlobKeys": {
+		files: map[string]string{"conf/x/a.conf": "a", "conf/b.conf": "b", "conf/c.conf": "c"},
+		ttext: `{{ range $name, $_ := .Files.Glob "conf/**" }}{{ $name }} {{ end }}{{ .Files.Glob "conf/**" | keys | join "," }}`,
+		want:  `conf/b.conf conf/c.conf conf/x/a.conf conf/b.conf,conf/c.conf,conf/x/a.conf`,
+	},
+
+	// KeyByPath prevents duplicate keys.
+	"KeyByPath": {
+		files: map[string]string{"conf/a/app.conf": "a", "conf/b/app.conf": "b"},
+		ttext: `{{ ((.Files.Glob "conf/**").KeyByPath "_").AsConfig }}`,
+		want: `a_app.conf: |-
+  a
+b_app.conf: |-
+  b
+`,
+	},
+
+	// AsConfigMap returns a manifest.
+	"AsConfigMap": {
+		files: map[string]string{"conf/app.conf": "a\n"},
+		ttext: `{{ (.Files.Glob "conf/*").AsConfigMap "app" (dict "namespace" "x" "labels" (dict "app" "foo")) }}`,
+		want: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+  namespace: x
+  labels:
+    app: foo
+data:
+  app.conf: |
+    a
+`,
+	},
+
+	// Render expands a file with the current values.
+	"Render": {
+		files: map[string]string{"snippets/probe.tpl": `path: /{{ .app | lower }}`},
+		ttext: `{{ .Files.Render "snippets/probe.tpl" (dict "app" "Foo") }} {{ tpl "{{ .app | upper }}" (dict "app" "Foo") }}`,
+		want:  `path: /foo FOO`,
+	},
+
+	// Exists and GetOr allow optional files.
+	"Optional": {
+		files: map[string]string{"env/dev.yaml": "dev"},
+		ttext: `{{ if .Files.Exists "env/dev.yaml" }}{{ .Files.Get "env/dev.yaml" }}{{ end }} {{ .Files.GetOr "env/prd.yaml" "default" }} {{ .Files.List "env" }} {{ (.Files.Stat "env/dev.yaml").Size }}`,
+		want:  `dev default [dev.yaml] 3`,
+	},
+
+	// Checksum is independent of the location of the files.
+	"Checksum": {
+		files: map[string]string{"config/a.conf": "a\n", "config/b/b.conf": "b\n"},
+		ttext: `{{ .Files.Checksum "config/**" }} {{ (.Files.Glob "config/*").Checksum "md5" | len }}`,
+		want:  `ffd19687396239aa07219b2674e1536df7f607c7b74fac3da278becd5d96debe 32`,
+	},
+}
+
+// TestFiles.
+func TestFiles(t *testing.T) {
+	for name, tst := range filesTests {
+		t.Run(name, func(t *testing.T) {
+			tf := testFilesNew()
+			defer tf.MustRemoveAll()
+			// create file(s)
+			for p, text := range tst.files {
+				tf.MustCreate(p, text)
+			}
+			tf.MustCreate("tpl.txt", tst.ttext)
+			// expand
+			var out bytes.Buffer
+			err := expand.Run(expand.Options{Template: tf.Path("tpl.txt")}, nil, &out)
+			assert.NoError(t, err)
+			// assert
+			assert.Equal(t, tst.want, out.String())
+		})
+	}
+}
+
+// TestFilesRoot tests if templates can't read files outside of the files root.
+func TestFilesRoot(t *testing.T) {
+	tf := testFilesNew()
+	defer tf.MustRemoveAll()
+	// create file(s)
+	tf.MustCreate("secret.txt", `secret`)
+	tf.MustCreate("cfg/shared.txt", `shared`)
+	tf.MustCreate("cfg/tpl/first.txt", `{{ .Files.Get "../shared.txt" }}`)
+	tf.MustCreate("cfg/tpl/second.txt", `
+{{ .Files.Get "../../secret.txt" }}`)
+	tf.MustCreate("cfg/all.yaml", `
+templates:
+- file: tpl/first.txt
+- file: tpl/second.txt`)
+	// expand
+	var out bytes.Buffer
+	err := expand.Run(expand.Options{All: tf.Path("cfg/all.yaml")}, nil, &out)
+	// assert
+	assert.EqualError(t, err, "expanding "+tf.Path("cfg/all.yaml")+`: template: second.txt:2:9: executing "second.txt" at <.Files.Get>: error calling Get: Files.Get ../../secret.txt: path is outside of files root `+tf.Path("cfg"))
+	assert.Equal(t, "shared\n", out.String())
+
+	// the root can be widened.
+	out.Reset()
+	err = expand.Run(expand.Options{All: tf.Path("cfg/all.yaml"), FilesRoot: tf.Path(".")}, nil, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, "shared\nsecret", out.String())
+}
+
+// TestFilesDuplicateKeys tests if duplicate keys stop the expansion.
+func TestFilesDuplicateKeys(t *testing.T) {
+	tf := testFilesNew()
+	defer tf.MustRemoveAll()
+	// create file(s)
+	tf.MustCreate("conf/a/app.conf", "a")
+	tf.MustCreate("conf/b/app.conf", "b")
+	tf.MustCreate("tpl.txt", `{{ (.Files.Glob "conf/**").AsConfig }}`)
+	// expand
+	var out bytes.Buffer
+	err := expand.Run(expand.Options{Template: tf.Path("tpl.txt")}, nil, &out)
+	// assert
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `have the same key "app.conf"`)
+	}
+}
+
+// TestFilesMaxSize tests if Glob reads files on access and if the total size read is limited.
+func TestFilesMaxSize(t *testing.T) {
+	tf := testFilesNew()
+	defer tf.MustRemoveAll()
+	// create file(s)
+	tf.MustCreate("certs/a.pem", "aaaa")
+	tf.MustCreate("certs/b.pem", "bbbb")
+	tf.MustCreate("tpl.txt", `{{ range $name, $_ := .Files.Glob "certs/*" }}{{ $name }} {{ end }}
+{{ range $name, $content := .Files.Glob "certs/*" }}{{ $content }}{{ end }}`)
+	// expand
+	var out bytes.Buffer
+	err := expand.Run(expand.Options{Template: tf.Path("tpl.txt"), FilesMaxSize: 8}, nil, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, "certs/a.pem certs/b.pem \naaaabbbb", out.String())
+
+	// ranging over the names doesn't read the files, using the content does.
+	tf.MustCreate("tpl.txt", `{{ range $name, $_ := .Files.Glob "certs/*" }}{{ $name }} {{ end }}
+{{ (.Files.Glob "certs/*").AsConfig }}`)
+	out.Reset()
+	err = expand.Run(expand.Options{Template: tf.Path("tpl.txt"), FilesMaxSize: 6}, nil, &out)
+	assert.EqualError(t, err, `expanding: template: tpl.txt:2:26: executing "tpl.txt" at <(.Files.Glob "certs/*").AsConfig>: error calling AsConfig: AsConfig: Files.Glob certs/*: certs/b.pem: more than the maximum total size of 6 bytes`)
+	assert.Equal(t, "certs/a.pem certs/b.pem \n", out.String())
+
+	// printing a file that can't be read fails after the template.
+	tf.MustCreate("tpl.txt", `{{ range $name, $content := .Files.Glob "certs/*" }}{{ $content }}{{ end }}`)
+	out.Reset()
+	err = expand.Run(expand.Options{Template: tf.Path("tpl.txt"), FilesMaxSize: 6}, nil, &out)
+	assert.EqualError(t, err, "expanding: tpl.txt: Files.Glob certs/*: certs/b.pem: more than the maximum total size of 6 bytes")
+}
+
+// TestFilesRender tests if errors in rendered files name the file and if recursion is limited.
+func TestFilesRender(t *testing.T) {
+	tf := testFilesNew()
+	defer tf.MustRemoveAll()
+	// create file(s)
+	tf.MustCreate("snippets/bad.tpl", `ok
+{{ fail "boom" }}`)
+	tf.MustCreate("snippets/loop.tpl", `{{ .Files.Render "snippets/loop.tpl" . }}`)
+	tf.MustCreate("tpl.txt", `{{ .Files.Render "snippets/bad.tpl" . }}`)
+	// expand
+	var out bytes.Buffer
+	err := expand.Run(expand.Options{Template: tf.Path("tpl.txt")}, nil, &out)
+	// assert
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `template: snippets/bad.tpl:2:3: executing "snippets/bad.tpl" at <fail "boom">: error calling fail: boom`)
+	}
+
+	tf.MustCreate("tpl.txt", `{{ .Files.Render "snippets/loop.tpl" . }}`)
+	err = expand.Run(expand.Options{Template: tf.Path("tpl.txt")}, nil, &out)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `snippets/loop.tpl: maximum nesting depth of 10 exceeded`)
+	}
+
+	tf.MustCreate("tpl.txt", `{{ tpl "{{ tpl . . }}" "{{ tpl . . }}" }}`)
+	err = expand.Run(expand.Options{Template: tf.Path("tpl.txt")}, nil, &out)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `tpl: maximum nesting depth of 10 exceeded`)
+	}
+}
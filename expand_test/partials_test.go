@@ -0,0 +1,62 @@
+// test templates that use partials.
+package expand_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mmlt/tool-tmplt/expand"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPartials tests if partials listed in the config and on the cli can be used in all templates.
+func TestPartials(t *testing.T) {
+	tf := testFilesNew()
+	defer tf.MustRemoveAll()
+	// create file(s)
+	tf.MustCreate("partials/_helpers.tpl", `
+{{- define "labels" -}}
+app: {{ .Values.app }}
+{{- end }}`)
+	tf.MustCreate("shared/_name.tpl", `{{ define "name" }}{{ .Values.app }}-{{ .Values.env }}{{ end }}`)
+	tf.MustCreate("tpl/first.txt", `labels:
+{{ include "labels" . | indent 2 }}
+`)
+	tf.MustCreate("tpl/second.txt", `{{ template "name" . }} {{ tpl "{{ include \"labels\" . }}" . }}`)
+	tf.MustCreate("all.yaml", `
+partials: partials/_*.tpl
+templates:
+- file: tpl/first.txt
+- file: tpl/second.txt
+values:
+  app: foo
+  env: dev`)
+	// expand
+	var out bytes.Buffer
+	err := expand.Run(expand.Options{All: tf.Path("all.yaml"), Partials: tf.Path("shared/*.tpl")}, nil, &out)
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, `labels:
+  app: foo
+foo-dev app: foo`, out.String())
+}
+
+// TestPartialsDuplicate tests if templates that are defined more than once are rejected.
+func TestPartialsDuplicate(t *testing.T) {
+	tf := testFilesNew()
+	defer tf.MustRemoveAll()
+	// create file(s)
+	tf.MustCreate("partials/a.tpl", `{{ define "labels" }}a{{ end }}`)
+	tf.MustCreate("partials/b.tpl", `{{ define "labels" }}b{{ end }}`)
+	tf.MustCreate("tpl.txt", `{{ template "labels" . }}`)
+	// expand
+	var out bytes.Buffer
+	err := expand.Run(expand.Options{Template: tf.Path("tpl.txt"), Partials: tf.Path("partials/*.tpl")}, nil, &out)
+	// assert
+	assert.EqualError(t, err, `expanding: partials: template "labels" is defined in partial `+tf.Path("partials/a.tpl")+` and `+tf.Path("partials/b.tpl"))
+
+	tf.MustRemove("partials/b.tpl")
+	tf.MustCreate("tpl.txt", `{{ define "labels" }}c{{ end }}{{ template "labels" . }}`)
+	err = expand.Run(expand.Options{Template: tf.Path("tpl.txt"), Partials: tf.Path("partials/*.tpl")}, nil, &out)
+	assert.EqualError(t, err, `expanding: parsing of '`+tf.Path("tpl.txt")+`' failed: template "labels" is defined in tpl.txt and partial `+tf.Path("partials/a.tpl"))
+}
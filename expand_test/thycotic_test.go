@@ -0,0 +1,119 @@
+// test templates that use values from a (fake) Thycotic Secret Server.
+package expand_test
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mmlt/tool-tmplt/expand"
+	"github.com/mmlt/tool-tmplt/thycotic/thycotictest"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFunctionThycotic tests function 'thycotic' (reading from Secret Server)
+func TestFunctionThycotic(t *testing.T) {
+	srv := thycotictest.NewServer("pipo", "klukkluk", "circus")
+	defer srv.Close()
+	srv.Secrets[1234] = &thycotictest.Secret{
+		Items: map[string]string{"Username": "replication", "Password": "this-is-a-secret"},
+		SSH:   &thycotictest.SSH{Username: "deploy", Password: "ssh-secret", Host: "host.example.com", Port: "22"},
+	}
+
+	tf := testFilesNew()
+	defer tf.MustRemoveAll()
+	// create file(s)
+	tf.MustCreate("tpl/example.txt", `
+{{ thycotic 1234 "Username" }}:{{ thycotic 1234 "Password" }}
+{{ thycotic 1234 "Pin" }} {{ thycotic 99 "Password" }}
+{{ with thycoticSSH 1234 "host.example.com" }}{{ .username }}@{{ .host }}:{{ .port }}{{ end }}
+{{ env "PASSWORD" }}`)
+	env := map[string]string{"PASSWORD": "klukkluk"}
+	// expand
+	var out bytes.Buffer
+	opts := expand.Options{
+		Provider: "thycotic",
+		URL:      srv.URL,
+		Username: "pipo",
+		Password: "klukkluk",
+		Domain:   "circus",
+		Template: tf.Path("tpl/example.txt"),
+	}
+	err := expand.Run(opts, env, &out)
+	assert.NoError(t, err)
+	// assert
+	assert.Equal(t, `
+replication:this-is-a-secret
+<unknown-secret-field> <unknown-secret>
+deploy@host.example.com:22
+********`, out.String())
+
+	// the history index can be the result of sprig arithmetic (int64).
+	tf.MustCreate("tpl/example.txt", `{{ thycotic 1234 "Username" (sub 1 1) }}`)
+	out.Reset()
+	err = expand.Run(opts, env, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, "replication", out.String())
+
+	// a failing history lookup fails instead of expanding a placeholder.
+	tf.MustCreate("tpl/example.txt", `{{ thycotic 1234 "Password" 5 }}`)
+	out.Reset()
+	err = expand.Run(opts, env, &out)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "thycotic: secret 1234 field Password has no version 5 (0 versions available)")
+	}
+	assert.Equal(t, "", out.String())
+
+	// thycoticSSH takes one machine.
+	tf.MustCreate("tpl/example.txt", `{{ thycoticSSH 1234 "host" ".example.com" }}`)
+	out.Reset()
+	err = expand.Run(opts, env, &out)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "thycoticSSH: expected at most 2 arguments, got 3")
+	}
+
+	// thycoticSSH fails instead of expanding empty fields.
+	tf.MustCreate("tpl/example.txt", `{{ (thycoticSSH 99 "host.example.com").password }}`)
+	out.Reset()
+	err = expand.Run(opts, env, &out)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "thycoticSSH: no ssh credentials 99: Access Denied or Secret 99 does not exist.")
+	}
+	assert.Equal(t, "", out.String())
+}
+
+// TestThycoticRetry tests if failing Secret Server calls are retried and if the deadline is enforced.
+func TestThycoticRetry(t *testing.T) {
+	srv := thycotictest.NewServer("pipo", "klukkluk", "circus")
+	defer srv.Close()
+	srv.Secrets[1234] = &thycotictest.Secret{Items: map[string]string{"Password": "this-is-a-secret"}}
+
+	tf := testFilesNew()
+	defer tf.MustRemoveAll()
+	tf.MustCreate("tpl/example.txt", `{{ thycotic 1234 "Password" }}`)
+	// expand
+	var out bytes.Buffer
+	opts := expand.Options{
+		Provider: "thycotic",
+		URL:      srv.URL,
+		Username: "pipo",
+		Password: "klukkluk",
+		Domain:   "circus",
+		Template: tf.Path("tpl/example.txt"),
+		Retries:  2,
+		Timeout:  time.Second,
+	}
+	srv.Failures = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+	err := expand.Run(opts, nil, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, `this-is-a-secret`, out.String())
+	assert.Equal(t, 3, srv.Calls("Authenticate"))
+
+	// a template isn't expanded after the deadline.
+	tf.MustCreate("tpl/plain.txt", `plain`)
+	out.Reset()
+	err = expand.Run(expand.Options{Template: tf.Path("tpl/plain.txt"), Deadline: time.Nanosecond}, nil, &out)
+	assert.EqualError(t, err, "expanding: deadline of 1ns exceeded")
+	assert.Equal(t, "", out.String())
+}
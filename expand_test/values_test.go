@@ -102,7 +102,7 @@ func TestYamlFiles(t *testing.T) {
 			tf.MustCreate(tst.vpath, tst.vtext)
 			// expand
 			var out bytes.Buffer
-			err := expand.Run("", "", "", tf.Path(tst.tpath), tf.Path(tst.apath), tf.Path(tst.vpath), nil, &out)
+			err := expand.Run(expand.Options{Template: tf.Path(tst.tpath), All: tf.Path(tst.apath), SetFile: tf.Path(tst.vpath)}, nil, &out)
 			assert.NoError(t, err)
 			// assert
 			assert.Equal(t, tst.want, out.String())
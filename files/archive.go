@@ -0,0 +1,153 @@
+package files
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Archive returns the files in the given tar, tar.gz or zip archive keyed by their slash separated path in the
+// archive. The archive is read in memory, entries with absolute paths or paths outside the archive (../) are rejected
+// as are entries with the same path, links are skipped. The size of the archive and the total (decompressed) size of
+// the files are each limited to Dir.MaxSize.
+//
+// This is designed to be called from a template.
+//
+//	{{ ((.Files.Archive "bundle.tgz").Glob "conf/*.yaml").AsConfig | indent 4 }}
+//	{{ (.Files.Archive "bundle.zip").Get "README.txt" }}
+func (dir Dir) Archive(name string) (Files, error) {
+	p, err := dir.abs(name)
+	if err != nil {
+		return nil, fmt.Errorf("Files.Archive %s: %v", name, err)
+	}
+	b, err := readFile(p, &budget{max: dir.MaxSize})
+	if err != nil {
+		return nil, fmt.Errorf("Files.Archive %s: %v", name, err)
+	}
+	f, err := readArchive(b, &budget{max: dir.MaxSize})
+	if err != nil {
+		return nil, fmt.Errorf("Files.Archive %s: %v", name, err)
+	}
+	return f, nil
+}
+
+// Glob returns the files with a name that matches the glob patterns, see Dir.Glob.
+//
+//	{{ ((.Files.Archive "bundle.tgz").Glob "conf/**" "!**/*.md").AsConfig }}
+func (f Files) Glob(patterns ...string) (Files, error) {
+	m, err := newMatcher(patterns)
+	if err != nil {
+		return nil, fmt.Errorf("Glob %s: %v", strings.Join(patterns, " "), err)
+	}
+	r := Files{}
+	for n, v := range f {
+		if m.match(strings.TrimPrefix(path.Clean(slash(n)), "/")) {
+			r[n] = v
+		}
+	}
+	return r, nil
+}
+
+// ReadArchive returns the files in tar, tar.gz or zip archive 'b' within budget 'bg'.
+func readArchive(b []byte, bg *budget) (Files, error) {
+	switch {
+	case bytes.HasPrefix(b, []byte("PK\x03\x04")) || bytes.HasPrefix(b, []byte("PK\x05\x06")):
+		return readZip(b, bg)
+	case bytes.HasPrefix(b, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return readTar(zr, bg)
+	case len(b) > 262 && string(b[257:262]) == "ustar":
+		return readTar(bytes.NewReader(b), bg)
+	default:
+		return nil, fmt.Errorf("unsupported archive format (expected tar, tar.gz or zip)")
+	}
+}
+
+func readTar(r io.Reader, bg *budget) (Files, error) {
+	f := Files{}
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return f, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.Typeflag != tar.TypeReg {
+			// directories, links and devices have no content.
+			continue
+		}
+		n, err := entryName(h.Name)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := f[n]; ok {
+			return nil, fmt.Errorf("entry %s: same path as an earlier entry", h.Name)
+		}
+		b, err := bg.read(tr)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", h.Name, err)
+		}
+		f[n] = NewFile(b)
+	}
+}
+
+func readZip(b []byte, bg *budget) (Files, error) {
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+	f := Files{}
+	for _, e := range zr.File {
+		if !e.Mode().IsRegular() {
+			continue
+		}
+		n, err := entryName(e.Name)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := f[n]; ok {
+			return nil, fmt.Errorf("entry %s: same path as an earlier entry", e.Name)
+		}
+		rc, err := e.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", e.Name, err)
+		}
+		b, err := bg.read(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", e.Name, err)
+		}
+		f[n] = NewFile(b)
+	}
+	return f, nil
+}
+
+// EntryName returns the clean slash separated path of archive entry 'name'.
+// An error is returned for absolute paths and paths outside the archive.
+func entryName(name string) (string, error) {
+	s := slash(name)
+	if strings.HasPrefix(s, "/") || (len(s) > 1 && s[1] == ':') {
+		return "", fmt.Errorf("entry %s: absolute path", name)
+	}
+	p := path.Clean(s)
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", fmt.Errorf("entry %s: path is outside of the archive", name)
+	}
+	return p, nil
+}
+
+// Slash returns 'name' with backslashes (as used in some zip files) replaced by slashes.
+func slash(name string) string {
+	return strings.Replace(name, `\`, "/", -1)
+}
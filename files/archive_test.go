@@ -0,0 +1,114 @@
+package files_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Entry is a file in a test archive.
+type entry struct {
+	name, content string
+}
+
+func tarGz(t *testing.T, entries []entry) string {
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	tw := tar.NewWriter(zw)
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Name: "conf/", Typeflag: tar.TypeDir, Mode: 0755}))
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}))
+	for _, e := range entries {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: e.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(e.content))}))
+		_, err := tw.Write([]byte(e.content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, zw.Close())
+	return b.String()
+}
+
+func zipped(t *testing.T, entries []entry) string {
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		assert.NoError(t, err)
+		_, err = w.Write([]byte(e.content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, zw.Close())
+	return b.String()
+}
+
+func TestDir_Archive(t *testing.T) {
+	entries := []entry{{"./conf/app.yaml", "a: 1\n"}, {"conf/db.yaml", "b: 2\n"}, {"README.md", "readme"}}
+	dir := newDir(t, map[string]string{
+		"bundle.tgz": tarGz(t, entries),
+		"bundle.zip": zipped(t, entries),
+		"slip.tgz":   tarGz(t, []entry{{"conf/../../evil", "x"}}),
+		"abs.zip":    zipped(t, []entry{{"/etc/evil", "x"}}),
+		"notarchive": "plain text",
+	})
+	defer os.RemoveAll(dir.Path)
+
+	for _, name := range []string{"bundle.tgz", "bundle.zip"} {
+		f, err := dir.Archive(name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, []string{"README.md", "conf/app.yaml", "conf/db.yaml"}, f.Names(), name)
+		readme, err := f.Get("README.md")
+		assert.NoError(t, err, name)
+		assert.Equal(t, "readme", readme, name)
+
+		conf, err := f.Glob("conf/*.yaml")
+		assert.NoError(t, err, name)
+		got, err := conf.AsConfig()
+		assert.NoError(t, err, name)
+		assert.Equal(t, "app.yaml: |\n  a: 1\ndb.yaml: |\n  b: 2\n", got, name)
+	}
+
+	_, err := dir.Archive("slip.tgz")
+	assert.EqualError(t, err, "Files.Archive slip.tgz: entry conf/../../evil: path is outside of the archive")
+	_, err = dir.Archive("abs.zip")
+	assert.EqualError(t, err, "Files.Archive abs.zip: entry /etc/evil: absolute path")
+	_, err = dir.Archive("notarchive")
+	assert.EqualError(t, err, "Files.Archive notarchive: unsupported archive format (expected tar, tar.gz or zip)")
+	_, err = dir.Archive("../bundle.zip")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "path is outside of files root")
+	}
+
+	// entries with the same path are rejected.
+	dup := []entry{{"conf/app.yaml", "a: 1\n"}, {"./conf/app.yaml", "a: 2\n"}}
+	dir = newDir(t, map[string]string{"dup.tgz": tarGz(t, dup), "dup.zip": zipped(t, dup)})
+	defer os.RemoveAll(dir.Path)
+	for _, name := range []string{"dup.tgz", "dup.zip"} {
+		_, err = dir.Archive(name)
+		assert.EqualError(t, err, "Files.Archive "+name+": entry ./conf/app.yaml: same path as an earlier entry", name)
+	}
+}
+
+func TestDir_ArchiveMaxSize(t *testing.T) {
+	entries := []entry{{"a.txt", strings.Repeat("a", 4096)}, {"b.txt", strings.Repeat("b", 4096)}}
+	tgz := tarGz(t, entries)
+	dir := newDir(t, map[string]string{"bomb.tgz": tgz, "bomb.zip": zipped(t, entries)})
+	defer os.RemoveAll(dir.Path)
+
+	// the decompressed size is limited to MaxSize.
+	dir.MaxSize = 6000
+	for _, name := range []string{"bomb.tgz", "bomb.zip"} {
+		_, err := dir.Archive(name)
+		assert.EqualError(t, err, "Files.Archive "+name+": b.txt: more than the maximum total size of 6000 bytes", name)
+	}
+
+	// the archive itself is limited to MaxSize.
+	dir.MaxSize = int64(len(tgz)) - 1
+	_, err := dir.Archive("bomb.tgz")
+	assert.EqualError(t, err, fmt.Sprintf("Files.Archive bomb.tgz: more than the maximum total size of %d bytes", dir.MaxSize))
+}
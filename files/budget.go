@@ -0,0 +1,41 @@
+package files
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Budget limits the total number of bytes read, for example by the files of a Glob.
+type budget struct {
+	// Max is the number of bytes that can be read (0 means no limit), used is the number of bytes read.
+	max, used int64
+}
+
+// Read returns the content of 'r'.
+// An error is returned as soon as the total read exceeds the budget so no more than max+1 bytes are kept in memory.
+func (b *budget) read(r io.Reader) ([]byte, error) {
+	if b.max <= 0 {
+		return ioutil.ReadAll(r)
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(r, b.max-b.used+1))
+	if err != nil {
+		return nil, err
+	}
+	b.used += int64(len(content))
+	if b.used > b.max {
+		return nil, fmt.Errorf("more than the maximum total size of %d bytes", b.max)
+	}
+	return content, nil
+}
+
+// ReadFile returns the content of file 'p' within budget 'b'.
+func readFile(p string, b *budget) ([]byte, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return b.read(f)
+}
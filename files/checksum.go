@@ -0,0 +1,69 @@
+package files
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"path/filepath"
+	"strings"
+)
+
+// Hashes are the checksum algorithms by name.
+var hashes = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"sha1":   sha1.New,
+	"md5":    md5.New,
+}
+
+// Checksum returns the sha256 checksum of the files matching the glob 'patterns', see Glob.
+// The checksum covers the sorted file names (relative to the directory) and contents, so it only changes when files
+// are added, removed, renamed or changed. Use Files.Checksum to select another algorithm.
+//
+// This is designed to be called from a template.
+//
+//	annotations:
+//	  checksum/config: {{ .Files.Checksum "config/**" "!**/*.bak" }}
+func (dir Dir) Checksum(patterns ...string) (string, error) {
+	f, err := dir.Glob(patterns...)
+	if err != nil {
+		return "", err
+	}
+	return f.Checksum()
+}
+
+// Checksum returns a checksum of the sorted file names and contents.
+// The optional algorithm is sha256 (default), sha512, sha1 or md5.
+//
+//	checksum/config: {{ (.Files.Glob "config/*").Checksum "sha512" }}
+func (f Files) Checksum(algorithm ...string) (string, error) {
+	if len(algorithm) > 1 {
+		return "", fmt.Errorf("Checksum: expected at most 1 argument")
+	}
+	alg := "sha256"
+	if len(algorithm) == 1 {
+		alg = algorithm[0]
+	}
+	newHash, ok := hashes[alg]
+	if !ok {
+		return "", fmt.Errorf("Checksum: unknown algorithm %s (expected sha256, sha512, sha1 or md5)", alg)
+	}
+
+	// the checksum is taken over lines like the output of sha256sum.
+	var b strings.Builder
+	for _, n := range f.Names() {
+		c, err := f[n].Bytes()
+		if err != nil {
+			return "", fmt.Errorf("Checksum: %v", err)
+		}
+		h := newHash()
+		h.Write(c)
+		fmt.Fprintf(&b, "%x  %s\n", h.Sum(nil), filepath.ToSlash(n))
+	}
+	h := newHash()
+	h.Write([]byte(b.String()))
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
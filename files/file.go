@@ -0,0 +1,91 @@
+package files
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+)
+
+// File is the content of a file in a Files group.
+// Files returned by Glob are read on first access so globbing a large directory only costs memory for the files that
+// are used.
+type File struct {
+	// Path is the file to read, empty when the content is known upfront.
+	path string
+	// Name identifies the file in errors.
+	name string
+	// Budget limits the total size of the files read from path.
+	budget *budget
+	// Errs records the read errors that String can't return.
+	errs *Errors
+	// Loaded is true when content or err are set.
+	loaded  bool
+	content []byte
+	err     error
+}
+
+// NewFile returns a File with 'content'.
+func NewFile(content []byte) *File {
+	return &File{loaded: true, content: content}
+}
+
+// NewLazyFile returns a File that reads 'path' within budget 'b' on first access.
+func newLazyFile(path, name string, b *budget, errs *Errors) *File {
+	return &File{path: path, name: name, budget: b, errs: errs}
+}
+
+// Bytes returns the content of the file.
+func (f *File) Bytes() ([]byte, error) {
+	if f == nil {
+		return nil, nil
+	}
+	if !f.loaded {
+		f.content, f.err = f.load()
+		f.loaded = true
+	}
+	return f.content, f.err
+}
+
+// String returns the content of the file so {{ $content }} and {{ $content | toString }} work in a range over Files.
+// String can't return an error; a file that can't be read returns an empty string and the error is recorded in Errors
+// so the expansion fails after the template. Use Get or Bytes to get the error at the position of the call.
+func (f *File) String() string {
+	b, err := f.Bytes()
+	if err != nil {
+		f.errs.add(err)
+		return ""
+	}
+	return string(b)
+}
+
+// Load reads the file within the budget.
+func (f *File) load() ([]byte, error) {
+	content, err := readFile(f.path, f.budget)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", f.name, err)
+	}
+	return content, nil
+}
+
+// Errors records the errors of reading files that can't be returned to the template, see File.String.
+type Errors struct {
+	err error
+}
+
+// Err returns the first error recorded or nil.
+func (e *Errors) Err() error {
+	if e == nil {
+		return nil
+	}
+	return e.err
+}
+
+// Add records 'err' when it's the first error, a nil Errors only logs 'err'.
+func (e *Errors) add(err error) {
+	switch {
+	case e == nil:
+		glog.Error(err)
+	case e.err == nil:
+		e.err = err
+	}
+}
@@ -24,20 +24,42 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
-	"github.com/golang/glog"
+	"fmt"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 	"github.com/BurntSushi/toml"
 )
 
 // Dir is the path to load relative files from.
-type Dir string
+// When Root is set files outside Root (after resolving symlinks) can't be accessed.
+type Dir struct {
+	// Path is the directory relative file names are resolved against.
+	Path string
+	// Root is the directory that can't be escaped, empty means no restriction.
+	Root string
+	// Renderer expands the files passed to Render, nil means Render isn't supported.
+	Renderer RenderFunc
+	// MaxSize is the maximum total size in bytes of the content read from the files of a Glob or extracted by an
+	// Archive, 0 means no limit.
+	MaxSize int64
+	// Errors records the errors of reading files that can't be returned to the template, nil means they are logged.
+	Errors *Errors
+}
 
-// Files is a map of files in a chart that can be accessed from a template.
-type Files map[string]string
+// RenderFunc expands template 'text' named 'name' with 'data'.
+type RenderFunc func(name, text string, data interface{}) (string, error)
 
-//TODO type Files map[string][]byte
+// Files is a map of files in a chart that can be accessed from a template.
+// The content is kept as raw bytes so binary files (keystores, images, archives) are not altered.
+// The content of files returned by Glob is read when it's accessed, see File.
+// The values are *File so functions that take a string need toString; {{ $content | toString | indent 4 }}
+type Files map[string]*File
 
 // Get returns a string representation of the given file.
 //
@@ -45,97 +67,253 @@ type Files map[string]string
 // template.
 //
 //	{{.Files.Get "foo"}}
-func (dir Dir) Get(name string) string {
-	p := filepath.Join(string(dir), name)
+func (dir Dir) Get(name string) (string, error) {
+	b, err := dir.GetBytes(name)
+	return string(b), err
+}
+
+// GetBytes returns the content of the given file as a byte slice.
+//
+// This is designed to be called from a template.
+//
+//	{{ .Files.GetBytes "keystore.jks" | toString | b64enc }}
+func (dir Dir) GetBytes(name string) ([]byte, error) {
+	p, err := dir.abs(name)
+	if err != nil {
+		return nil, fmt.Errorf("Files.Get %s: %v", name, err)
+	}
 	b, err := ioutil.ReadFile(p)
 	if err != nil {
-		glog.Exitf("Files.Get failed: %v", err)
+		return nil, fmt.Errorf("Files.Get %s: %v", name, err)
+	}
+	return b, nil
+}
+
+// GetOr returns the content of the given file or 'def' when the file doesn't exist.
+//
+// This is designed to be called from a template.
+//
+//	{{ .Files.GetOr (printf "env/%s.yaml" .Values.env) "" }}
+func (dir Dir) GetOr(name, def string) (string, error) {
+	ok, err := dir.Exists(name)
+	if err != nil || !ok {
+		return def, err
+	}
+	return dir.Get(name)
+}
+
+// Exists returns true if the given file or directory exists.
+//
+// This is designed to be called from a template.
+//
+//	{{ if .Files.Exists "override.yaml" }}...{{ end }}
+func (dir Dir) Exists(name string) (bool, error) {
+	p, err := dir.abs(name)
+	if err != nil {
+		return false, fmt.Errorf("Files.Exists %s: %v", name, err)
+	}
+	_, err = os.Stat(p)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Files.Exists %s: %v", name, err)
+	}
+	return true, nil
+}
+
+// FileInfo describes a file as returned by Stat.
+type FileInfo struct {
+	// Name is the base name of the file.
+	Name string
+	// Size is the length in bytes.
+	Size int64
+	// Mode are the file mode bits, printed like -rw-r--r--
+	Mode os.FileMode
+	// ModTime is the modification time.
+	ModTime time.Time
+	// IsDir is true for directories.
+	IsDir bool
+}
+
+// Stat returns the size, mode and modification time of the given file.
+//
+// This is designed to be called from a template.
+//
+//	{{ (.Files.Stat "app.jar").Size }}
+func (dir Dir) Stat(name string) (FileInfo, error) {
+	p, err := dir.abs(name)
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("Files.Stat %s: %v", name, err)
 	}
-	return string(b)
+	fi, err := os.Stat(p)
+	if err != nil {
+		return FileInfo{}, fmt.Errorf("Files.Stat %s: %v", name, err)
+	}
+	return FileInfo{Name: fi.Name(), Size: fi.Size(), Mode: fi.Mode(), ModTime: fi.ModTime(), IsDir: fi.IsDir()}, nil
 }
 
-// Glob takes a glob pattern and returns another files object only containing
-// matched  files.
+// List returns the sorted names of the files and directories in the given directory.
+// A directory that doesn't exist returns an empty list.
 //
 // This is designed to be called from a template.
 //
-// {{ range $name, $content := .Files.Glob("foo/**") }}
+//	{{ range .Files.List "env" }}{{ . }}{{ end }}
+func (dir Dir) List(name string) ([]string, error) {
+	p, err := dir.abs(name)
+	if err != nil {
+		return nil, fmt.Errorf("Files.List %s: %v", name, err)
+	}
+	fis, err := ioutil.ReadDir(p)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Files.List %s: %v", name, err)
+	}
+	names := make([]string, 0, len(fis))
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Render returns the given file expanded as a template with 'data'.
+// The template has the same functions as the template calling Render.
+//
+// This is designed to be called from a template.
+//
+//	{{ .Files.Render "snippets/probe.tpl" . | indent 8 }}
+func (dir Dir) Render(name string, data interface{}) (string, error) {
+	if dir.Renderer == nil {
+		return "", fmt.Errorf("Files.Render %s: not supported", name)
+	}
+	s, err := dir.Get(name)
+	if err != nil {
+		return "", err
+	}
+	return dir.Renderer(name, s, data)
+}
+
+// Glob takes glob patterns and returns another files object only containing
+// matched files. Patterns support ** to match any number of directories and
+// {a,b} alternatives, patterns starting with ! exclude files, see glob.go
+//
+// The names are slash separated paths relative to the directory, so they are
+// the same regardless of the working directory and OS.
+//
+// The content of the files is read when it's used (not when only the names are
+// used) and the total size read is limited to Dir.MaxSize. Read errors are
+// returned by the Files methods that use the content (Get, AsConfig, ...), the
+// errors of printing a file ({{ $content }}) are recorded in Dir.Errors.
+//
+// This is designed to be called from a template.
+//
+// {{ range $name, $content := .Files.Glob "foo/**/*.conf" "!foo/**/test-*" }}
 // {{ $name }}: |
-// {{ .Files.Get($name) | indent 4 }}{{ end }}
-func (dir Dir) Glob(pattern string) Files {
-	p := filepath.Join(string(dir), pattern)
-	fs, err := filepath.Glob(p)
+// {{ $content | toString | indent 4 }}{{ end }}
+func (dir Dir) Glob(patterns ...string) (Files, error) {
+	fs, err := dir.glob(patterns)
 	if err != nil {
-		glog.Exitf("Files.Glob %v failed: %v", pattern, err)
+		return nil, fmt.Errorf("Files.Glob %s: %v", strings.Join(patterns, " "), err)
 	}
 
-	//TODO m := map[string][]byte{}
-	m := make(Files, 0)
-	for _, f := range fs {
-		b, err := ioutil.ReadFile(f)
-		if err != nil {
-			glog.Exitf("Files.Glob %v failed: %v", pattern, err)
-		}
-		m[f] = string(b)
+	b := &budget{max: dir.MaxSize}
+	m := make(Files, len(fs))
+	for _, n := range fs {
+		name := fmt.Sprintf("Files.Glob %s: %s", strings.Join(patterns, " "), n)
+		m[n] = newLazyFile(filepath.Join(dir.Path, filepath.FromSlash(n)), name, b, dir.Errors)
 	}
-	return m
+	return m, nil
+}
+
+// GlobExclude returns the files matching 'pattern' except the ones matching one of 'excludes'.
+//
+// {{ (.Files.GlobExclude "conf/**" "conf/**/*.bak" "conf/**/.*").AsConfig }}
+func (dir Dir) GlobExclude(pattern string, excludes ...string) (Files, error) {
+	patterns := []string{pattern}
+	for _, e := range excludes {
+		patterns = append(patterns, "!"+e)
+	}
+	return dir.Glob(patterns...)
+}
+
+// Names returns the sorted names of the files.
+//
+// {{ range (.Files.Glob "conf/**").Names }}{{ . }}{{ end }}
+func (f Files) Names() []string {
+	names := make([]string, 0, len(f))
+	for n := range f {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
 }
 
 // AsConfig turns a Files group and flattens it to a YAML map suitable for
 // including in the 'data' section of a Kubernetes ConfigMap definition.
-// Duplicate keys will be overwritten, so be aware that your file names
-// (regardless of path) should be unique.
+// The keys are the file names (regardless of path), an error is returned when
+// they are not unique or not valid ConfigMap keys. Use KeyByPath or KeyBy to
+// select other keys.
+//
+// The values are literal block scalars (|) so the content reads like the file
+// and round-trips unchanged, CRLF line endings are normalized to LF.
 //
 // This is designed to be called from a template, and will return empty string
-// (via ToYaml function) if it cannot be serialized to YAML, or if the Files
-// object is nil.
+// if the Files object is nil.
 //
 // The output will not be indented, so you will want to pipe this to the
 // 'indent' template function.
 //
 //   data:
-// {{ (.Files.Glob "config/**").AsConfig() | indent 4 }}
-func (f Files) AsConfig() string {
+// {{ (.Files.Glob "config/**").AsConfig | indent 4 }}
+func (f Files) AsConfig() (string, error) {
 	if f == nil {
-		return ""
+		return "", nil
 	}
 
-	m := map[string]string{}
-
-	// Explicitly convert to strings, and file names
-	for k, v := range f {
-		m[filepath.Base(k)] = string(v)
+	data, err := f.configData("AsConfig")
+	if err != nil {
+		return "", err
+	}
+	m := make(map[string]string, len(data))
+	for k, v := range data {
+		m[k] = normalizeNewlines(string(v))
 	}
 
-	return ToYaml(m)
+	return blockYaml(m), nil
 }
 
 // AsSecrets returns the base64-encoded value of a Files object suitable for
 // including in the 'data' section of a Kubernetes Secret definition.
-// Duplicate keys will be overwritten, so be aware that your file names
-// (regardless of path) should be unique.
+// The keys are the same as for AsConfig.
+//
+// The values are literal block scalars (|-) like the values of AsConfig.
 //
 // This is designed to be called from a template, and will return empty string
-// (via ToYaml function) if it cannot be serialized to YAML, or if the Files
-// object is nil.
+// if the Files object is nil.
 //
 // The output will not be indented, so you will want to pipe this to the
 // 'indent' template function.
 //
 //   data:
-// {{ (.Files.Glob "secrets/*").AsSecrets() }}
-func (f Files) AsSecrets() string {
+// {{ (.Files.Glob "secrets/*").AsSecrets | indent 4 }}
+func (f Files) AsSecrets() (string, error) {
 	if f == nil {
-		return ""
+		return "", nil
 	}
 
-	m := map[string]string{}
-
-	for k, v := range f {
-		m[filepath.Base(k)] = string(base64.StdEncoding.EncodeToString([]byte(v)))
+	data, err := f.configData("AsSecrets")
+	if err != nil {
+		return "", err
+	}
+	m := make(map[string]string, len(data))
+	for k, v := range data {
+		m[k] = base64.StdEncoding.EncodeToString(v)
 	}
 
-	return ToYaml(m)
+	return blockYaml(m), nil
 }
 
 // Lines returns each line of a named file (split by "\n") as a slice, so it can
@@ -145,13 +323,139 @@ func (f Files) AsSecrets() string {
 //
 // {{ range .Files.Lines "foo/bar.html" }}
 // {{ . }}{{ end }}
-//func (f Files) Lines(path string) []string {
-//	if f == nil || f[path] == nil {
-//		return []string{}
-//	}
+func (dir Dir) Lines(name string) ([]string, error) {
+	s, err := dir.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return lines(s), nil
+}
+
+// Head returns the first n lines of a named file that are not blank or comment
+// (starting with #) lines.
+//
+// This is designed to be called from a template.
+//
+// {{ range .Files.Head "hosts.txt" 3 }}
+// {{ . }}{{ end }}
+func (dir Dir) Head(name string, n int) ([]string, error) {
+	s, err := dir.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return head(s, n), nil
+}
+
+// Grep returns the lines of a named file that match the regular expression
+// 'pattern'. Blank and comment (starting with #) lines are skipped.
+//
+// This is designed to be called from a template.
+//
+// {{ range .Files.Grep "allow.txt" "^10\\." }}
+// {{ . }}{{ end }}
+func (dir Dir) Grep(name, pattern string) ([]string, error) {
+	s, err := dir.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return grep(s, pattern)
+}
+
+// Get returns the content of a file in a Files group as a string.
+// An unknown path returns an empty string.
+//
+//	{{ (.Files.Glob "conf/*").Get "conf/app.conf" }}
+func (f Files) Get(path string) (string, error) {
+	b, err := f.GetBytes(path)
+	return string(b), err
+}
+
+// GetBytes returns the content of a file in a Files group as a byte slice.
+// An unknown path returns nil.
+func (f Files) GetBytes(path string) ([]byte, error) {
+	// a nil map and a nil *File both result in nil.
+	return f[path].Bytes()
+}
+
+// Lines returns each line of a file in a Files group as a slice.
+// An unknown path returns an empty slice.
 //
-//	return strings.Split(string(f[path]), "\n")
-//}
+// {{ range (.Files.Glob "hosts/*").Lines "hosts/a.txt" }}
+// {{ . }}{{ end }}
+func (f Files) Lines(path string) ([]string, error) {
+	s, err := f.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	return lines(s), nil
+}
+
+// Head returns the first n non-blank, non-comment lines of a file in a Files group.
+func (f Files) Head(path string, n int) ([]string, error) {
+	s, err := f.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	return head(s, n), nil
+}
+
+// Grep returns the non-blank, non-comment lines of a file in a Files group that
+// match the regular expression 'pattern'.
+func (f Files) Grep(path, pattern string) ([]string, error) {
+	s, err := f.Get(path)
+	if err != nil {
+		return nil, err
+	}
+	return grep(s, pattern)
+}
+
+// Lines splits 's' in lines without line endings.
+// A final line ending doesn't result in an empty last line.
+func lines(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	ls := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	for i, l := range ls {
+		ls[i] = strings.TrimSuffix(l, "\r")
+	}
+	return ls
+}
+
+// Content returns the lines of 's' that are not blank or comments, with surrounding white space removed.
+func content(s string) []string {
+	r := []string{}
+	for _, l := range lines(s) {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		r = append(r, l)
+	}
+	return r
+}
+
+func head(s string, n int) []string {
+	ls := content(s)
+	if n >= 0 && n < len(ls) {
+		ls = ls[:n]
+	}
+	return ls
+}
+
+func grep(s, pattern string) ([]string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("Files.Grep %s: %v", pattern, err)
+	}
+	r := []string{}
+	for _, l := range content(s) {
+		if re.MatchString(l) {
+			r = append(r, l)
+		}
+	}
+	return r, nil
+}
 
 // ToYaml takes an interface, marshals it to yaml, and returns a string. It will
 // always return a string, even on marshal error (empty string).
@@ -0,0 +1,521 @@
+package files_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mmlt/tool-tmplt/files"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+// NewDir returns a temporary directory with 'content' by file name.
+func newDir(t *testing.T, content map[string]string) files.Dir {
+	dir, err := ioutil.TempDir("", "files")
+	assert.NoError(t, err)
+	for name, text := range content {
+		p := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(p), 0700))
+		assert.NoError(t, ioutil.WriteFile(p, []byte(text), 0600))
+	}
+	return files.Dir{Path: dir, Root: dir}
+}
+
+const hosts = "# hosts\r\n10.0.0.1 a\r\n\r\n  # disabled\r\n10.0.0.2 b\r\n192.168.0.1 c\r\n"
+
+func TestDir_Lines(t *testing.T) {
+	dir := newDir(t, map[string]string{"hosts.txt": hosts, "empty.txt": ""})
+	defer os.RemoveAll(dir.Path)
+
+	got, err := dir.Lines("hosts.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"# hosts", "10.0.0.1 a", "", "  # disabled", "10.0.0.2 b", "192.168.0.1 c"}, got)
+	got, err = dir.Lines("empty.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, got)
+	got, err = dir.Head("hosts.txt", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1 a", "10.0.0.2 b"}, got)
+	got, err = dir.Head("hosts.txt", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1 a", "10.0.0.2 b", "192.168.0.1 c"}, got)
+	got, err = dir.Grep("hosts.txt", `^10\.`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1 a", "10.0.0.2 b"}, got)
+
+	_, err = dir.Grep("hosts.txt", `(`)
+	assert.EqualError(t, err, "Files.Grep (: error parsing regexp: missing closing ): `(`")
+	_, err = dir.Lines("missing.txt")
+	assert.Error(t, err)
+}
+
+func TestFiles_Lines(t *testing.T) {
+	f := files.Files{"hosts.txt": files.NewFile([]byte(hosts))}
+
+	got, err := f.Head("hosts.txt", -1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1 a", "10.0.0.2 b", "192.168.0.1 c"}, got)
+	got, err = f.Grep("hosts.txt", "^192")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"192.168.0.1 c"}, got)
+	got, err = f.Lines("hosts.txt")
+	assert.NoError(t, err)
+	assert.Len(t, got, 6)
+	got, err = f.Lines("unknown.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, got)
+
+	var nilFiles files.Files
+	got, err = nilFiles.Lines("hosts.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, got)
+}
+
+func TestFiles_Binary(t *testing.T) {
+	bin := string([]byte{0x1f, 0x8b, 0x08, 0x00, 0xff, 0xfe, 0x00, '\r', '\n'})
+	dir := newDir(t, map[string]string{"secrets/blob.gz": bin, "secrets/text.txt": "héllo\n"})
+	defer os.RemoveAll(dir.Path)
+
+	b, err := dir.GetBytes("secrets/blob.gz")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(bin), b)
+
+	f, err := dir.Glob("secrets/*")
+	assert.NoError(t, err)
+	b, err = f.GetBytes("secrets/blob.gz")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(bin), b)
+	assert.Equal(t, "héllo\n", f["secrets/text.txt"].String())
+
+	s, err := f.AsSecrets()
+	assert.NoError(t, err)
+	m := files.FromYaml(s)
+	assert.Equal(t, "H4sIAP/+AA0K", m["blob.gz"])
+	assert.Equal(t, "aMOpbGxvCg==", m["text.txt"])
+}
+
+func TestDir_Glob(t *testing.T) {
+	dir := newDir(t, map[string]string{
+		"conf/app.conf":           "a",
+		"conf/app.yaml":           "b",
+		"conf/nested/db.conf":     "c",
+		"conf/nested/deep/x.ini":  "d",
+		"conf/nested/test-x.conf": "e",
+		"conf/.hidden.conf":       "f",
+		"other/app.conf":          "g",
+	})
+	defer os.RemoveAll(dir.Path)
+
+	tests := []struct {
+		it       string
+		patterns []string
+		want     []string
+	}{
+		{
+			it:       "should match a single directory",
+			patterns: []string{"conf/*.conf"},
+			want:     []string{"conf/.hidden.conf", "conf/app.conf"},
+		},
+		{
+			it:       "should match recursively",
+			patterns: []string{"conf/**/*.conf"},
+			want:     []string{"conf/.hidden.conf", "conf/app.conf", "conf/nested/db.conf", "conf/nested/test-x.conf"},
+		},
+		{
+			it:       "should match all files",
+			patterns: []string{"conf/**"},
+			want:     []string{"conf/.hidden.conf", "conf/app.conf", "conf/app.yaml", "conf/nested/db.conf", "conf/nested/deep/x.ini", "conf/nested/test-x.conf"},
+		},
+		{
+			it:       "should match brace alternatives",
+			patterns: []string{"{conf,other}/app.{conf,yaml}"},
+			want:     []string{"conf/app.conf", "conf/app.yaml", "other/app.conf"},
+		},
+		{
+			it:       "should exclude negated patterns",
+			patterns: []string{"**/*.conf", "!**/test-*", "!**/.*"},
+			want:     []string{"conf/app.conf", "conf/nested/db.conf", "other/app.conf"},
+		},
+		{
+			it:       "should return nothing for a missing directory",
+			patterns: []string{"missing/**"},
+			want:     []string{},
+		},
+	}
+	for _, tst := range tests {
+		got, err := dir.Glob(tst.patterns...)
+		assert.NoError(t, err, tst.it)
+		assert.Equal(t, len(tst.want), len(got), tst.it)
+		if len(tst.want) > 0 {
+			assert.Equal(t, tst.want, got.Names(), tst.it)
+		}
+	}
+
+	got, err := dir.GlobExclude("conf/**", "**/*.conf", "conf/nested/deep/**")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"conf/app.yaml"}, got.Names())
+
+	_, err = dir.Glob("conf/{a,b")
+	assert.EqualError(t, err, `Files.Glob conf/{a,b: pattern "conf/{a,b": unbalanced braces`)
+}
+
+func TestDir_GlobLazy(t *testing.T) {
+	dir := newDir(t, map[string]string{
+		"certs/a.pem": "aaaa",
+		"certs/b.pem": "bbbb",
+		"certs/c.pem": "cccc",
+	})
+	defer os.RemoveAll(dir.Path)
+	dir.MaxSize = 10
+
+	f, err := dir.Glob("certs/*")
+	assert.NoError(t, err)
+
+	// content is read on access, names don't need the content.
+	assert.NoError(t, os.Remove(filepath.Join(dir.Path, "certs/c.pem")))
+	assert.Len(t, f.Names(), 3)
+	_, err = f.Get("certs/c.pem")
+	assert.Error(t, err)
+
+	// the total size of the content read is limited.
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir.Path, "certs/c.pem"), []byte("cccc"), 0600))
+	f, err = dir.Glob("certs/*")
+	assert.NoError(t, err)
+	assert.Equal(t, "aaaa", f["certs/a.pem"].String())
+	_, err = f.AsConfig()
+	assert.EqualError(t, err, "AsConfig: Files.Glob certs/*: certs/c.pem: more than the maximum total size of 10 bytes")
+
+	// the limit applies to each Glob.
+	f, err = dir.Glob("certs/{a,b}.pem")
+	assert.NoError(t, err)
+	_, err = f.AsConfig()
+	assert.NoError(t, err)
+
+	// String can't return the error, it's recorded.
+	dir.Errors = &files.Errors{}
+	f, err = dir.Glob("certs/*")
+	assert.NoError(t, err)
+	assert.NoError(t, os.Remove(filepath.Join(dir.Path, "certs/c.pem")))
+	assert.Equal(t, "aaaa", f["certs/a.pem"].String())
+	assert.Equal(t, "", f["certs/c.pem"].String())
+	if assert.Error(t, dir.Errors.Err()) {
+		assert.Contains(t, dir.Errors.Err().Error(), "Files.Glob certs/*: certs/c.pem: open ")
+	}
+}
+
+func TestDir_Root(t *testing.T) {
+	outside := newDir(t, map[string]string{"shadow": "secret"})
+	defer os.RemoveAll(outside.Path)
+	root := newDir(t, map[string]string{"tpl/app.conf": "a", "shared/common.conf": "c"})
+	defer os.RemoveAll(root.Path)
+	assert.NoError(t, os.Symlink(filepath.Join(outside.Path, "shadow"), filepath.Join(root.Path, "tpl", "link")))
+	assert.NoError(t, os.Symlink(outside.Path, filepath.Join(root.Path, "outside")))
+
+	dir := files.Dir{Path: filepath.Join(root.Path, "tpl"), Root: root.Path}
+
+	tests := []struct {
+		it      string
+		name    string
+		wantErr string
+	}{
+		{
+			it:   "should allow files in the template directory",
+			name: "app.conf",
+		},
+		{
+			it:   "should allow files in the root",
+			name: "../shared/common.conf",
+		},
+		{
+			it:      "should reject relative paths outside the root",
+			name:    "../../" + filepath.Base(outside.Path) + "/shadow",
+			wantErr: "path is outside of files root",
+		},
+		{
+			it:      "should treat absolute paths as relative",
+			name:    filepath.Join(outside.Path, "shadow"),
+			wantErr: "no such file or directory",
+		},
+		{
+			it:      "should reject symlinks to files outside the root",
+			name:    "link",
+			wantErr: "path is outside of files root " + root.Path + " (symlink to ",
+		},
+		{
+			it:      "should reject symlinks to directories outside the root",
+			name:    "../outside/shadow",
+			wantErr: "path is outside of files root " + root.Path + " (symlink to ",
+		},
+	}
+	for _, tst := range tests {
+		_, err := dir.Get(tst.name)
+		if tst.wantErr == "" {
+			assert.NoError(t, err, tst.it)
+		} else if assert.Error(t, err, tst.it) {
+			assert.Contains(t, err.Error(), tst.wantErr, tst.it)
+		}
+	}
+
+	_, err := dir.Glob("../../**")
+	assert.Error(t, err, "should reject patterns outside the root")
+	_, err = dir.Glob("*")
+	assert.Error(t, err, "should reject matched symlinks outside the root")
+	got, err := dir.Glob("*.conf")
+	assert.NoError(t, err)
+	assert.Len(t, got, 1)
+
+	// without root all files can be accessed.
+	dir.Root = ""
+	s, err := dir.Get("link")
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", s)
+}
+
+func TestFiles_AsConfig(t *testing.T) {
+	f := files.Files{
+		"a/policy.rego": files.NewFile([]byte("package kubernetes.admission\r\n\r\n# deny if\r\ndeny[msg] {\r\n\tmsg := \"no\"\r\n}\r\n")),
+		"b/none":        files.NewFile([]byte("no newline")),
+		"b/keep":        files.NewFile([]byte("two newlines\n\n")),
+		"b/indented":    files.NewFile([]byte("  indented\nnot\n")),
+		"b/empty":       files.NewFile([]byte("")),
+		"b/control":     files.NewFile([]byte("bell\a")),
+		"c/Makefile":    files.NewFile([]byte("\tx")),
+		"c/table.tsv":   files.NewFile([]byte("\n\ta\tb\n1\t2\n")),
+	}
+
+	got, err := f.AsConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, `Makefile: |2-
+  	x
+control: "bell\a"
+empty: ""
+indented: |2
+    indented
+  not
+keep: |+
+  two newlines
+
+none: |-
+  no newline
+policy.rego: |
+  package kubernetes.admission
+
+  # deny if
+  deny[msg] {
+  	msg := "no"
+  }
+table.tsv: |2
+
+  	a	b
+  1	2
+`, got)
+
+	// round trip
+	m := map[string]string{}
+	assert.NoError(t, yaml.Unmarshal([]byte(got), &m))
+	for k, v := range f {
+		assert.Equal(t, strings.Replace(v.String(), "\r\n", "\n", -1), m[filepath.Base(k)], k)
+	}
+}
+
+func TestFiles_AsSecrets(t *testing.T) {
+	f := files.Files{"tls.key": files.NewFile([]byte("key\r\n"))}
+	got, err := f.AsSecrets()
+	assert.NoError(t, err)
+	assert.Equal(t, "tls.key: |-\n  a2V5DQo=\n", got)
+}
+
+func TestFiles_Keys(t *testing.T) {
+	f := files.Files{
+		filepath.FromSlash("/tpl/conf/a/app.conf"): files.NewFile([]byte("a")),
+		filepath.FromSlash("/tpl/conf/b/app.conf"): files.NewFile([]byte("b")),
+		filepath.FromSlash("/tpl/conf/c.conf"):     files.NewFile([]byte("c")),
+	}
+
+	_, err := f.AsConfig()
+	assert.EqualError(t, err, "AsConfig: "+filepath.FromSlash("/tpl/conf/a/app.conf")+" and "+filepath.FromSlash("/tpl/conf/b/app.conf")+` have the same key "app.conf", use KeyByPath or KeyBy to make keys unique`)
+	_, err = f.AsSecrets()
+	assert.Error(t, err)
+
+	byPath, err := f.KeyByPath("_")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a_app.conf", "b_app.conf", "c.conf"}, byPath.Names())
+	got, err := byPath.AsConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, "a_app.conf: |-\n  a\nb_app.conf: |-\n  b\nc.conf: |-\n  c\n", got)
+
+	_, err = f.KeyByPath("/")
+	assert.EqualError(t, err, `KeyByPath: separator "/" should only contain -._a-zA-Z0-9`)
+
+	by, err := f.KeyBy(`{{ .Dir | replace "/" "-" }}-{{ .Base | upper }}`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{".-C.CONF", "a-APP.CONF", "b-APP.CONF"}, by.Names())
+
+	_, err = f.KeyBy(`{{ .Ext }}`)
+	assert.EqualError(t, err, "KeyBy: "+filepath.FromSlash("/tpl/conf/a/app.conf")+" and "+filepath.FromSlash("/tpl/conf/b/app.conf")+` have the same key ".conf"`)
+	_, err = f.KeyBy(`{{ .Path }}`)
+	assert.EqualError(t, err, "KeyBy "+filepath.FromSlash("/tpl/conf/a/app.conf")+`: key "a/app.conf" should consist of at most 253 -._a-zA-Z0-9 characters`)
+
+	_, err = files.Files{"my file.txt": files.NewFile([]byte("x"))}.AsConfig()
+	assert.EqualError(t, err, `AsConfig my file.txt: key "my file.txt" should consist of at most 253 -._a-zA-Z0-9 characters`)
+}
+
+func TestFiles_AsConfigMap(t *testing.T) {
+	f := files.Files{
+		"conf/app.conf": files.NewFile([]byte("a: 1\r\n")),
+		"conf/logo.png": files.NewFile([]byte{0x89, 'P', 'N', 'G', 0xff}),
+	}
+
+	got, err := f.AsConfigMap("app", map[string]interface{}{
+		"namespace":   "team",
+		"labels":      map[string]interface{}{"app": "foo", "tier": 1},
+		"annotations": map[interface{}]interface{}{"owner": "team"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+  namespace: team
+  labels:
+    app: foo
+    tier: "1"
+  annotations:
+    owner: team
+data:
+  app.conf: |
+    a: 1
+binaryData:
+  logo.png: |-
+    iVBOR/8=
+`, got)
+
+	// the hash changes with the content.
+	got, err = f.AsConfigMap("app", map[string]interface{}{"hash": true})
+	assert.NoError(t, err)
+	assert.Contains(t, got, "  name: app-")
+	f["conf/app.conf"] = files.NewFile([]byte("a: 2\n"))
+	other, err := f.AsConfigMap("app", map[string]interface{}{"hash": true})
+	assert.NoError(t, err)
+	assert.NotEqual(t, got, other)
+	assert.Regexp(t, `name: app-[bcdfghkmt2456789]{10}\n`, other)
+
+	_, err = f.AsConfigMap("app", map[string]interface{}{"namespaces": "x"})
+	assert.EqualError(t, err, "AsConfigMap: unknown option namespaces (expected one of namespace, labels, annotations, hash)")
+	_, err = f.AsConfigMap("app", map[string]interface{}{"hash": "yes"})
+	assert.EqualError(t, err, "AsConfigMap: option hash: expected a bool, got string")
+}
+
+func TestFiles_AsSecret(t *testing.T) {
+	f := files.Files{
+		"tls/tls.crt": files.NewFile([]byte("crt\n")),
+		"tls/tls.key": files.NewFile([]byte{0xff, 0x00}),
+	}
+
+	got, err := f.AsSecret("app-tls", map[string]interface{}{"type": "kubernetes.io/tls"})
+	assert.NoError(t, err)
+	assert.Equal(t, `apiVersion: v1
+kind: Secret
+metadata:
+  name: app-tls
+type: kubernetes.io/tls
+data:
+  tls.crt: |-
+    Y3J0Cg==
+  tls.key: |-
+    /wA=
+`, got)
+
+	got, err = f.AsSecret("app-tls", map[string]interface{}{"stringData": true})
+	assert.NoError(t, err)
+	assert.Equal(t, `apiVersion: v1
+kind: Secret
+metadata:
+  name: app-tls
+type: Opaque
+data:
+  tls.key: |-
+    /wA=
+stringData:
+  tls.crt: |
+    crt
+`, got)
+}
+
+func TestDir_Metadata(t *testing.T) {
+	dir := newDir(t, map[string]string{"env/dev.yaml": "dev: true\n", "env/prd.yaml": "", "app.jar": "12345"})
+	defer os.RemoveAll(dir.Path)
+
+	ok, err := dir.Exists("env/dev.yaml")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = dir.Exists("env/tst.yaml")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	_, err = dir.Exists("../x")
+	assert.Error(t, err, "should reject paths outside the root")
+
+	s, err := dir.GetOr("env/dev.yaml", "none")
+	assert.NoError(t, err)
+	assert.Equal(t, "dev: true\n", s)
+	s, err = dir.GetOr("env/tst.yaml", "none")
+	assert.NoError(t, err)
+	assert.Equal(t, "none", s)
+
+	fi, err := dir.Stat("app.jar")
+	assert.NoError(t, err)
+	assert.Equal(t, "app.jar", fi.Name)
+	assert.Equal(t, int64(5), fi.Size)
+	assert.Equal(t, "-rw-------", fi.Mode.String())
+	assert.False(t, fi.IsDir)
+	assert.WithinDuration(t, time.Now(), fi.ModTime, time.Minute)
+	_, err = dir.Stat("missing")
+	assert.Error(t, err)
+
+	names, err := dir.List(".")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"app.jar", "env"}, names)
+	names, err = dir.List("env")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dev.yaml", "prd.yaml"}, names)
+	names, err = dir.List("missing")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, names)
+}
+
+func TestFiles_Checksum(t *testing.T) {
+	dir := newDir(t, map[string]string{"config/a.conf": "a\n", "config/b/b.conf": "b\n"})
+	defer os.RemoveAll(dir.Path)
+
+	// echo a > config/a.conf; echo b > config/b/b.conf; sha256sum config/a.conf config/b/b.conf | sha256sum
+	got, err := dir.Checksum("config/**")
+	assert.NoError(t, err)
+	assert.Equal(t, "ffd19687396239aa07219b2674e1536df7f607c7b74fac3da278becd5d96debe", got)
+
+	// patterns are the same as for Glob.
+	dir = newDir(t, map[string]string{"config/a.conf": "a\n", "config/b/b.conf": "b\n", "config/a.conf.bak": "old\n"})
+	defer os.RemoveAll(dir.Path)
+	excluded, err := dir.Checksum("config/**", "!**/*.bak")
+	assert.NoError(t, err)
+	assert.Equal(t, got, excluded)
+
+	// the same content with other names has another checksum.
+	other, err := files.Files{"a.conf": files.NewFile([]byte("a\n")), "b/b.conf": files.NewFile([]byte("b\n"))}.Checksum()
+	assert.NoError(t, err)
+	assert.NotEqual(t, got, other)
+
+	f := files.Files{"config/a.conf": files.NewFile([]byte("a\n")), "config/b/b.conf": files.NewFile([]byte("b\n"))}
+	same, err := f.Checksum("sha256")
+	assert.NoError(t, err)
+	assert.Equal(t, got, same)
+	md5, err := f.Checksum("md5")
+	assert.NoError(t, err)
+	assert.Len(t, md5, 32)
+
+	_, err = f.Checksum("crc32")
+	assert.EqualError(t, err, "Checksum: unknown algorithm crc32 (expected sha256, sha512, sha1 or md5)")
+}
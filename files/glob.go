@@ -0,0 +1,245 @@
+package files
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Glob patterns are slash separated paths relative to a Dir with the following syntax:
+//	*	any sequence of non-separator characters
+//	**	as a complete path segment; zero or more directories
+//	?	any single non-separator character
+//	[a-z]	a character class, see path.Match
+//	{a,b}	alternatives, can be nested
+//	!p	(first character) excludes the files matching p
+
+// Matcher matches slash separated paths against include and exclude patterns.
+type matcher struct {
+	// Include and exclude are the patterns (with braces expanded) split in path segments.
+	include, exclude [][]string
+}
+
+// NewMatcher returns a matcher for 'patterns'.
+// At least one pattern must be an include (not starting with !) pattern.
+func newMatcher(patterns []string) (*matcher, error) {
+	m := &matcher{}
+	for _, p := range patterns {
+		neg := strings.HasPrefix(p, "!")
+		alts, err := expandBraces(strings.TrimPrefix(p, "!"))
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q: %v", p, err)
+		}
+		for _, a := range alts {
+			segs := strings.Split(path.Clean(strings.TrimLeft(a, "/")), "/")
+			for _, s := range segs {
+				if _, err := path.Match(s, ""); err != nil {
+					return nil, fmt.Errorf("pattern %q: %v", p, err)
+				}
+			}
+			if neg {
+				m.exclude = append(m.exclude, segs)
+			} else {
+				m.include = append(m.include, segs)
+			}
+		}
+	}
+	if len(m.include) == 0 {
+		return nil, fmt.Errorf("no include pattern in %q", patterns)
+	}
+	return m, nil
+}
+
+// Match returns true if 'name' matches an include pattern and no exclude pattern.
+func (m *matcher) match(name string) bool {
+	segs := strings.Split(name, "/")
+	return matchAny(m.include, segs) && !matchAny(m.exclude, segs)
+}
+
+// Dir returns true if files below directory 'name' (slash separated) can match an include pattern.
+func (m *matcher) dir(name string) bool {
+	segs := strings.Split(name, "/")
+	for _, p := range m.include {
+		if matchDir(p, segs) {
+			return true
+		}
+	}
+	return false
+}
+
+// Root returns the directory (slash separated) that contains all matches, "." for the current directory.
+func (m *matcher) root() string {
+	var root []string
+	for i, segs := range m.include {
+		// literal prefix of the pattern, the last segment is a file name.
+		n := 0
+		for n < len(segs)-1 && !hasMeta(segs[n]) {
+			n++
+		}
+		if i == 0 {
+			root = segs[:n]
+			continue
+		}
+		j := 0
+		for j < len(root) && j < n && root[j] == segs[j] {
+			j++
+		}
+		root = root[:j]
+	}
+	if len(root) == 0 {
+		return "."
+	}
+	return strings.Join(root, "/")
+}
+
+// Glob returns the sorted paths of the files in 'dir' that match 'patterns'.
+func (dir Dir) glob(patterns []string) ([]string, error) {
+	m, err := newMatcher(patterns)
+	if err != nil {
+		return nil, err
+	}
+	var r []string
+	root, err := dir.abs(filepath.FromSlash(m.root()))
+	if err != nil {
+		return nil, err
+	}
+	err = filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if p == root && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			if p == root {
+				return nil
+			}
+			rel, err := filepath.Rel(dir.Path, p)
+			if err != nil {
+				return err
+			}
+			if !m.dir(filepath.ToSlash(rel)) {
+				// don't walk directories that can't contain matches, like the subdirectories for *.yaml
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir.Path, p)
+		if err != nil {
+			return err
+		}
+		if !m.match(filepath.ToSlash(rel)) {
+			return nil
+		}
+		if dir.Root != "" {
+			// a symlinked file can point outside the root.
+			if err := dir.check(p); err != nil {
+				return fmt.Errorf("%s: %v", filepath.ToSlash(rel), err)
+			}
+		}
+		r = append(r, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(r)
+	return r, nil
+}
+
+func matchAny(patterns [][]string, name []string) bool {
+	for _, p := range patterns {
+		if matchSegments(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchDir returns true if a path below directory 'dir' can match pattern 'pat'.
+func matchDir(pat, dir []string) bool {
+	for len(dir) > 0 {
+		if len(pat) == 0 {
+			return false
+		}
+		if pat[0] == "**" {
+			return true
+		}
+		if ok, _ := path.Match(pat[0], dir[0]); !ok {
+			return false
+		}
+		pat, dir = pat[1:], dir[1:]
+	}
+	// a file in dir needs at least one more segment.
+	return len(pat) > 0
+}
+
+// MatchSegments returns true if path segments 'name' match pattern segments 'pat'.
+func matchSegments(pat, name []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			pat = pat[1:]
+			if len(pat) == 0 {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pat, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], name[0]); !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// ExpandBraces returns the alternatives of 'pattern', for example a/{b,c{d,e}} returns a/b, a/cd, a/ce
+func expandBraces(pattern string) ([]string, error) {
+	start := strings.IndexByte(pattern, '{')
+	if start < 0 {
+		return []string{pattern}, nil
+	}
+	depth, last := 0, start+1
+	var options []string
+	for i := start; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '{':
+			depth++
+		case ',':
+			if depth == 1 {
+				options = append(options, pattern[last:i])
+				last = i + 1
+			}
+		case '}':
+			depth--
+			if depth > 0 {
+				continue
+			}
+			options = append(options, pattern[last:i])
+			var r []string
+			for _, o := range options {
+				alts, err := expandBraces(pattern[:start] + o + pattern[i+1:])
+				if err != nil {
+					return nil, err
+				}
+				r = append(r, alts...)
+			}
+			return r, nil
+		}
+	}
+	return nil, fmt.Errorf("unbalanced braces")
+}
+
+func hasMeta(s string) bool {
+	return strings.ContainsAny(s, `*?[{\`)
+}
@@ -0,0 +1,30 @@
+package files
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatcher_Dir(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		dir      string
+		want     bool
+	}{
+		{patterns: []string{"*.yaml"}, dir: "conf", want: false},
+		{patterns: []string{"conf/*.yaml"}, dir: "conf", want: true},
+		{patterns: []string{"conf/*.yaml"}, dir: "conf/nested", want: false},
+		{patterns: []string{"conf/*.yaml"}, dir: "other", want: false},
+		{patterns: []string{"conf/**/*.yaml"}, dir: "conf/a/b/c", want: true},
+		{patterns: []string{"*/x/*.yaml"}, dir: "a/x", want: true},
+		{patterns: []string{"*/x/*.yaml"}, dir: "a/y", want: false},
+		{patterns: []string{"{a,b}/*", "!a/**"}, dir: "b", want: true},
+		{patterns: []string{"../shared/*.tpl"}, dir: "../shared", want: true},
+	}
+	for _, tst := range tests {
+		m, err := newMatcher(tst.patterns)
+		assert.NoError(t, err)
+		assert.Equal(t, tst.want, m.dir(tst.dir), "%v %s", tst.patterns, tst.dir)
+	}
+}
@@ -0,0 +1,132 @@
+package files
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig"
+)
+
+// ConfigKeyRE matches valid ConfigMap and Secret keys.
+var configKeyRE = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+
+// KeyData is the data a KeyBy template is executed with.
+type KeyData struct {
+	// Name is the file name as in Files.
+	Name string
+	// Path is the slash separated path relative to the common directory of the files.
+	Path string
+	// Dir, Base and Ext are the directory, file name and extension of Path.
+	Dir, Base, Ext string
+}
+
+// KeyByPath returns the files keyed by their path relative to the common directory of the files with / replaced
+// by 'sep'. Use it to prevent duplicate keys in AsConfig and AsSecrets.
+//
+// For conf/a/app.conf and conf/b/app.conf the keys are a_app.conf and b_app.conf:
+//
+//	data:
+//	{{ ((.Files.Glob "conf/**").KeyByPath "_").AsConfig | indent 4 }}
+func (f Files) KeyByPath(sep string) (Files, error) {
+	if sep != "" && !configKeyRE.MatchString(sep) {
+		return nil, fmt.Errorf("KeyByPath: separator %q should only contain -._a-zA-Z0-9", sep)
+	}
+	return f.rekey("KeyByPath", func(d KeyData) (string, error) {
+		return strings.Replace(d.Path, "/", sep, -1), nil
+	})
+}
+
+// KeyBy returns the files keyed by the result of template 'text' executed with KeyData.
+// The template has the sprig functions.
+//
+//	data:
+//	{{ ((.Files.Glob "conf/**").KeyBy "{{ .Dir | replace \"/\" \"-\" }}-{{ .Base }}").AsConfig | indent 4 }}
+func (f Files) KeyBy(text string) (Files, error) {
+	tmpl, err := template.New("KeyBy").Funcs(sprig.TxtFuncMap()).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return f.rekey("KeyBy", func(d KeyData) (string, error) {
+		var b bytes.Buffer
+		err := tmpl.Execute(&b, d)
+		return b.String(), err
+	})
+}
+
+// Rekey returns the files keyed by 'key'. Keys should be unique valid ConfigMap keys.
+func (f Files) rekey(fn string, key func(KeyData) (string, error)) (Files, error) {
+	names := f.Names()
+	dir := commonDir(names)
+	r := make(Files, len(f))
+	from := make(map[string]string, len(f))
+	for _, n := range names {
+		p := strings.TrimPrefix(filepath.ToSlash(n), dir)
+		d := KeyData{Name: n, Path: p, Dir: path.Dir(p), Base: path.Base(p), Ext: path.Ext(p)}
+		k, err := key(d)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %v", fn, n, err)
+		}
+		if err := checkKey(k); err != nil {
+			return nil, fmt.Errorf("%s %s: %v", fn, n, err)
+		}
+		if other, ok := from[k]; ok {
+			return nil, fmt.Errorf("%s: %s and %s have the same key %q", fn, other, n, k)
+		}
+		from[k] = n
+		r[k] = f[n]
+	}
+	return r, nil
+}
+
+// ConfigData returns the file content by base name.
+// An error is returned when keys aren't unique or aren't valid ConfigMap keys.
+func (f Files) configData(fn string) (map[string][]byte, error) {
+	names := f.Names()
+	m := make(map[string][]byte, len(f))
+	from := make(map[string]string, len(f))
+	for _, n := range names {
+		k := filepath.Base(n)
+		if err := checkKey(k); err != nil {
+			return nil, fmt.Errorf("%s %s: %v", fn, n, err)
+		}
+		if other, ok := from[k]; ok {
+			return nil, fmt.Errorf("%s: %s and %s have the same key %q, use KeyByPath or KeyBy to make keys unique", fn, other, n, k)
+		}
+		from[k] = n
+		b, err := f[n].Bytes()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fn, err)
+		}
+		m[k] = b
+	}
+	return m, nil
+}
+
+// CheckKey returns an error when 'k' isn't a valid ConfigMap key.
+func checkKey(k string) error {
+	if len(k) > 253 || !configKeyRE.MatchString(k) || k == "." || k == ".." {
+		return fmt.Errorf("key %q should consist of at most 253 -._a-zA-Z0-9 characters", k)
+	}
+	return nil
+}
+
+// CommonDir returns the longest directory (slash separated, ending with /) that contains all 'names'.
+func commonDir(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	sorted := append([]string{}, names...)
+	sort.Strings(sorted)
+	first, last := filepath.ToSlash(sorted[0]), filepath.ToSlash(sorted[len(sorted)-1])
+	i := 0
+	for i < len(first) && i < len(last) && first[i] == last[i] {
+		i++
+	}
+	return first[:strings.LastIndex(first[:i], "/")+1]
+}
@@ -0,0 +1,254 @@
+package files
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ManifestOptions are the options of AsConfigMap and AsSecret as passed from a template with dict.
+type manifestOptions struct {
+	// Namespace, Labels and Annotations are the metadata of the manifest.
+	Namespace   string
+	Labels      map[string]string
+	Annotations map[string]string
+	// Hash when true appends a hash of the content to the name, like Kustomize generators do.
+	Hash bool
+	// StringData when true puts the UTF-8 files of a Secret in stringData instead of data.
+	StringData bool
+	// Type is the Secret type, default Opaque.
+	Type string
+}
+
+// Metadata is the metadata section of a manifest.
+type metadata struct {
+	Name        string            `yaml:"name"`
+	Namespace   string            `yaml:"namespace,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// AsConfigMap returns a Kubernetes ConfigMap manifest named 'name' with the files as data.
+// Files that are not valid UTF-8 are put in binaryData.
+// The optional dict sets namespace, labels, annotations and hash (true to append a content hash to the name).
+// Keys are the same as for AsConfig.
+//
+// {{ (.Files.Glob "conf/*").AsConfigMap "app-config" (dict "namespace" "x" "labels" (dict "app" "foo") "hash" true) }}
+func (f Files) AsConfigMap(name string, options ...map[string]interface{}) (string, error) {
+	opts, err := parseManifestOptions("AsConfigMap", options, "namespace", "labels", "annotations", "hash")
+	if err != nil {
+		return "", err
+	}
+	in, err := f.configData("AsConfigMap")
+	if err != nil {
+		return "", err
+	}
+
+	data := map[string]string{}
+	binaryData := map[string]string{}
+	for k, v := range in {
+		if utf8.Valid(v) {
+			data[k] = normalizeNewlines(string(v))
+		} else {
+			binaryData[k] = base64.StdEncoding.EncodeToString(v)
+		}
+	}
+
+	if opts.Hash {
+		name += "-" + contentHash(map[string]interface{}{"kind": "ConfigMap", "name": name, "data": data, "binaryData": binaryData})
+	}
+
+	var b strings.Builder
+	b.WriteString("apiVersion: v1\nkind: ConfigMap\n")
+	writeMetadata(&b, name, opts)
+	writeData(&b, "data", data)
+	writeData(&b, "binaryData", binaryData)
+	return b.String(), nil
+}
+
+// AsSecret returns a Kubernetes Secret manifest named 'name' with the files as base64 encoded data.
+// The optional dict sets namespace, labels, annotations, hash (true to append a content hash to the name),
+// type (default Opaque) and stringData (true to put the UTF-8 files as plain text in stringData).
+// Keys are the same as for AsConfig.
+//
+// {{ (.Files.Glob "tls/*").AsSecret "app-tls" (dict "type" "kubernetes.io/tls") }}
+func (f Files) AsSecret(name string, options ...map[string]interface{}) (string, error) {
+	opts, err := parseManifestOptions("AsSecret", options, "namespace", "labels", "annotations", "hash", "stringData", "type")
+	if err != nil {
+		return "", err
+	}
+	in, err := f.configData("AsSecret")
+	if err != nil {
+		return "", err
+	}
+	if opts.Type == "" {
+		opts.Type = "Opaque"
+	}
+
+	data := map[string]string{}
+	stringData := map[string]string{}
+	for k, v := range in {
+		if opts.StringData && utf8.Valid(v) {
+			stringData[k] = string(v)
+		} else {
+			data[k] = base64.StdEncoding.EncodeToString(v)
+		}
+	}
+
+	if opts.Hash {
+		name += "-" + contentHash(map[string]interface{}{"kind": "Secret", "name": name, "type": opts.Type, "data": data, "stringData": stringData})
+	}
+
+	var b strings.Builder
+	b.WriteString("apiVersion: v1\nkind: Secret\n")
+	writeMetadata(&b, name, opts)
+	b.WriteString("type: " + strings.TrimSuffix(ToYaml(opts.Type), "\n") + "\n")
+	writeData(&b, "data", data)
+	writeData(&b, "stringData", stringData)
+	return b.String(), nil
+}
+
+// ParseManifestOptions returns the options in the (optional) dict passed from a template.
+// Only the 'allowed' keys are accepted.
+func parseManifestOptions(fn string, options []map[string]interface{}, allowed ...string) (manifestOptions, error) {
+	var opts manifestOptions
+	if len(options) > 1 {
+		return opts, fmt.Errorf("%s: expected at most 2 arguments", fn)
+	}
+	if len(options) == 0 {
+		return opts, nil
+	}
+	keys := make([]string, 0, len(options[0]))
+	for k := range options[0] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := options[0][k]
+		if !contains(allowed, k) {
+			return opts, fmt.Errorf("%s: unknown option %s (expected one of %s)", fn, k, strings.Join(allowed, ", "))
+		}
+		var err error
+		switch k {
+		case "namespace":
+			opts.Namespace, err = optString(v)
+		case "type":
+			opts.Type, err = optString(v)
+		case "labels":
+			opts.Labels, err = optMap(v)
+		case "annotations":
+			opts.Annotations, err = optMap(v)
+		case "hash":
+			opts.Hash, err = optBool(v)
+		case "stringData":
+			opts.StringData, err = optBool(v)
+		}
+		if err != nil {
+			return opts, fmt.Errorf("%s: option %s: %v", fn, k, err)
+		}
+	}
+	return opts, nil
+}
+
+func optString(v interface{}) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("expected a string, got %T", v)
+	}
+	return s, nil
+}
+
+func optBool(v interface{}) (bool, error) {
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expected a bool, got %T", v)
+	}
+	return b, nil
+}
+
+// OptMap returns a map (like a dict or a map from .Values) with its keys and values as strings.
+func optMap(v interface{}) (map[string]string, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Map {
+		return nil, fmt.Errorf("expected a map, got %T", v)
+	}
+	m := make(map[string]string, rv.Len())
+	for _, k := range rv.MapKeys() {
+		m[fmt.Sprint(k.Interface())] = fmt.Sprint(rv.MapIndex(k).Interface())
+	}
+	return m, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteMetadata writes the metadata section of a manifest to 'b'.
+func writeMetadata(b *strings.Builder, name string, opts manifestOptions) {
+	b.WriteString("metadata:\n")
+	b.WriteString(indent(ToYaml(metadata{
+		Name:        name,
+		Namespace:   opts.Namespace,
+		Labels:      opts.Labels,
+		Annotations: opts.Annotations,
+	}), "  "))
+}
+
+// WriteData writes a data section named 'section' to 'b', empty sections are omitted.
+func writeData(b *strings.Builder, section string, data map[string]string) {
+	if len(data) == 0 {
+		return
+	}
+	b.WriteString(section + ":\n")
+	b.WriteString(indent(blockYaml(data), "  "))
+}
+
+// Indent prefixes the non-empty lines of 's' with 'prefix'.
+func indent(s, prefix string) string {
+	ls := strings.Split(s, "\n")
+	for i, l := range ls {
+		if l != "" {
+			ls[i] = prefix + l
+		}
+	}
+	return strings.Join(ls, "\n")
+}
+
+// ContentHash returns a hash of 'v' in the same format as the name suffix of Kustomize generators.
+func contentHash(v map[string]interface{}) string {
+	for k, x := range v {
+		if m, ok := x.(map[string]string); ok && len(m) == 0 {
+			delete(v, k)
+		}
+	}
+	b, _ := json.Marshal(v)
+	sum := sha256.Sum256(b)
+	enc := []byte(hex.EncodeToString(sum[:])[:10])
+	// avoid vowels and digits that look like letters so the hash doesn't form words.
+	for i, c := range enc {
+		switch c {
+		case '0':
+			enc[i] = 'g'
+		case '1':
+			enc[i] = 'h'
+		case '3':
+			enc[i] = 'k'
+		case 'a':
+			enc[i] = 'm'
+		case 'e':
+			enc[i] = 't'
+		}
+	}
+	return string(enc)
+}
@@ -0,0 +1,60 @@
+package files
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Abs returns the path of 'name' relative to dir.Path.
+// An error is returned when the path, after resolving symlinks, is outside dir.Root.
+// Absolute names are relative to dir.Path too.
+func (dir Dir) abs(name string) (string, error) {
+	p := filepath.Join(dir.Path, name)
+	if dir.Root == "" {
+		return p, nil
+	}
+	return p, dir.check(p)
+}
+
+// Check returns an error when path 'p' is outside dir.Root.
+// Symlinks are resolved, a path that doesn't exist is checked as is.
+func (dir Dir) check(p string) error {
+	root, err := filepath.Abs(dir.Root)
+	if err != nil {
+		return err
+	}
+	ap, err := filepath.Abs(p)
+	if err != nil {
+		return err
+	}
+	if !within(root, ap) {
+		return fmt.Errorf("path is outside of files root %s", dir.Root)
+	}
+
+	rp, err := filepath.EvalSymlinks(ap)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	rroot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	if !within(rroot, rp) {
+		return fmt.Errorf("path is outside of files root %s (symlink to %s)", dir.Root, rp)
+	}
+	return nil
+}
+
+// Within returns true if absolute path 'p' is 'root' or in 'root'.
+func within(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,81 @@
+package files
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// BlockYaml returns 'm' as a YAML map with the values as literal block scalars, so the values read the same as the
+// files they come from. The chomping indicator is chosen to preserve trailing line breaks, an indentation indicator
+// is added when a value starts with a space or tab.
+// Values that can't be represented as block scalar (control characters, invalid UTF-8) are emitted quoted.
+func blockYaml(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		v := m[k]
+		key := strings.TrimSuffix(ToYaml(k), "\n")
+		if v == "" || !blockable(v) {
+			b.WriteString(ToYaml(map[string]string{k: v}))
+			continue
+		}
+		b.WriteString(key)
+		b.WriteString(": ")
+		b.WriteString(blockHeader(v))
+		b.WriteString("\n")
+		for _, l := range strings.Split(strings.TrimSuffix(v, "\n"), "\n") {
+			if l != "" {
+				b.WriteString("  ")
+				b.WriteString(l)
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// BlockHeader returns the literal block scalar header for 'v'.
+func blockHeader(v string) string {
+	h := "|"
+	if first := strings.TrimLeft(v, "\n"); strings.HasPrefix(first, " ") || strings.HasPrefix(first, "\t") {
+		h += "2"
+	}
+	switch {
+	case !strings.HasSuffix(v, "\n"):
+		h += "-"
+	case strings.HasSuffix(v, "\n\n") || v == "\n":
+		h += "+"
+	}
+	return h
+}
+
+// Blockable returns true if 's' only contains characters that can be represented in a block scalar.
+func blockable(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		switch {
+		case r == '\t' || r == '\n':
+		case r < 0x20 || r == 0x7f:
+			return false
+		case r >= 0x80 && r < 0xa0:
+			// C1 control characters including NEL (a line break in YAML 1.1).
+			return false
+		case r == '\u2028' || r == '\u2029' || r == '\ufeff' || r == '\ufffe' || r == '\uffff':
+			return false
+		}
+	}
+	return true
+}
+
+// NormalizeNewlines replaces CRLF line endings by LF.
+func normalizeNewlines(s string) string {
+	return strings.Replace(s, "\r\n", "\n", -1)
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"github.com/golang/glog"
 	"os"
+	"time"
+	"github.com/mmlt/tool-tmplt/azkv"
 	"github.com/mmlt/tool-tmplt/expand"
 )
 
@@ -15,7 +17,7 @@ var (
 	provider = flag.String("provider", "",
 		`Provider is thycotic | azkv when secrets need to be fetched.`)
 	url = flag.String("url", "",
-		`Url:port of the secret store. For provider=azkv use https://name-of-keyvault.vault.azure.net`)
+		`Url:port of the secret store. For provider=azkv use https://name-of-keyvault.vault.azure.net or name-of-keyvault`)
 	username = flag.String("u", "",
 		`For provider=thycotic; username of account to retrieve secret with.`)
 	passw = flag.String("p", "",
@@ -28,6 +30,27 @@ var (
 		`Filename of a yaml file that lists templates and the values to expand.`)
 	setFile = flag.String("set-file", "",
 		`Filename of a yaml file with values.`)
+	lockFile = flag.String("lock-file", "",
+		`For provider=azkv; filename of a yaml file that records the version of each secret, certificate and key used.`)
+	frozen = flag.Bool("frozen", false,
+		`For provider=azkv; expand secrets, certificates and keys with the versions recorded in -lock-file (the lock file is not updated).`)
+	azureAuth = flag.String("azure-auth", "environment",
+		`For provider=azkv; authentication method environment | cli | msi | certificate | file.`)
+	azureCloud = flag.String("azure-cloud", "",
+		`For provider=azkv; Azure cloud public | usgovernment | china | german | AzureStackCloud | <file>.json (default AZURE_ENVIRONMENT or public).`)
+	timeout = flag.Duration("timeout", 30*time.Second,
+		`Maximum duration of a secret store call, 0 means no timeout.`)
+	retries = flag.Int("retries", 3,
+		`Maximum number of retries of a secret store call that failed with a throttling (429), server (5xx) or network error.`)
+	deadline = flag.Duration("deadline", 0,
+		`Maximum duration of the secret store calls, also checked before each template is expanded, 0 means no deadline.`)
+	partials = flag.String("partials", "",
+		`Glob of files with templates ({{ define "name" }}) that can be used in all templates, see also partials in the -a file.`)
+	filesRoot = flag.String("files-root", "",
+		`Directory that {{ .Files }} can't access files outside of (default the directory of -t or -a).`)
+	filesMaxSize = flag.Int64("files-max-size", 64<<20,
+		`Maximum total size in bytes of the files read by a {{ .Files.Glob }} or extracted by a {{ .Files.Archive }}, 0 means no limit.`)
+	vaults = azkv.Vaults{}
 	usage = `tmplt %s 
 tmplt reads template files and expands {{ }} occurrences. Output goes to stdout.
 
@@ -55,27 +78,121 @@ Other functions:
     the corresponding Thycotic secret value (in this example 1234 represents the secret ID (an int32) and "Password"
     represents the field name of the secret value).
     Thycotic authentication uses -u and -p values.
+    An optional third argument selects a historical value; {{thycotic 1234 "Password" "2019-11-01T12:00:00Z"}} returns
+    the value as of the given time (RFC3339 or 2006-01-02) and {{thycotic 1234 "Password" 1}} returns the value before
+    the most recent one (0 is the most recent value).
+
+    thycoticSSH - When provider=thycotic is selected {{thycoticSSH 1234 "host.example.com"}} returns a map with the
+    username, password, host, port and privateKey of the SSH credentials of secret 1234 for the given machine.
+    The machine argument is optional. For example: {{ (thycoticSSH 1234 "host.example.com").username }}
+    Credentials that can't be read stop the expansion.
 
     secret - When provider=azkv is selected occurrences like {{secret name-of-secret"}} are replaced with the
     corresponding value from https://name-of-keyvault.vault.azure.net/secrets/name-of-secret.
 	Name-of-secret should match [0-9a-zA-Z\-]
-    AZ KeyVault authentication uses -url value and AZURE_TENANT_ID, AZURE_CLIENT_ID, AZURE_CLIENT_SECRET environment variables.
-    See https://docs.microsoft.com/en-us/azure/go/azure-sdk-go-authorization#use-environment-based-authentication
+    A specific version is selected with {{secret "name-of-secret" "version-id"}}.
+    With -lock-file the version of each secret, certificate and key used is recorded, with -lock-file and -frozen the
+    recorded versions are used (expansion fails when a secret, certificate or key is not recorded).
+    Secrets in other vaults are selected with {{secret "alias" "name-of-secret"}} (optionally followed by a version),
+    the alias is defined with -vault alias=https://other-keyvault.vault.azure.net or in the -a file:
+        vaults:
+          alias: https://other-keyvault.vault.azure.net
+    kvCertificate, kvCertificateBundle and kvKey accept an alias as first argument too.
+
+    kvCertificate, kvCertificateBundle, kvKey - When provider=azkv is selected {{kvCertificate "name"}} returns the
+    public certificate in PEM format, {{kvCertificateBundle "name"}} returns the private key followed by the certificate
+    chain in PEM format (PFX is converted to PEM) and {{kvKey "name"}} returns the public key in PEM format
+    ({{kvKey "name" "jwk"}} returns a JSON Web Key).
+
+    kvSecretDecoded - When provider=azkv is selected {{kvSecretDecoded "name"}} returns the value of a secret decoded
+    according to its content type; application/json is returned as a map, application/x-pkcs12 as a PEM bundle,
+    application/octet-stream (or a content type with encoding=base64) as raw bytes and text/plain as is.
+    Other content types stop the expansion. The arguments are the same as for secret.
+    For example: {{ (kvSecretDecoded "db-config").password }}
+
+    kvSecrets, kvSecretsByTag - When provider=azkv is selected {{kvSecrets "app-foo-"}} returns a map of the names and
+    values of the secrets with a name that starts with "app-foo-" and {{kvSecretsByTag "team" "foo"}} returns the
+    secrets with tag team=foo. Disabled and expired secrets are skipped. An alias can be passed as first argument.
+    A failure to list the secrets stops the expansion.
+    For example: {{ range $name, $value := kvSecrets "app-foo-" }}{{ $name }}: {{ $value | b64enc }}{{ end }}
+
+    AZ KeyVault authentication is selected with -azure-auth:
+      environment - AZURE_TENANT_ID, AZURE_CLIENT_ID, AZURE_CLIENT_SECRET environment variables (default).
+      cli - the login of the Azure CLI (az login).
+      msi - the managed identity of the VM or App Service, set AZURE_CLIENT_ID to select a user assigned identity.
+      certificate - AZURE_TENANT_ID, AZURE_CLIENT_ID, AZURE_CERTIFICATE_PATH (a PFX file) and optionally
+        AZURE_CERTIFICATE_PASSWORD environment variables.
+      file - the file at AZURE_AUTH_LOCATION as created by 'az ad sp create-for-rbac --sdk-auth'.
+    See https://docs.microsoft.com/en-us/azure/go/azure-sdk-go-authorization
+    The Azure cloud (authority, Key Vault resource and vault DNS suffix) is selected with -azure-cloud or the
+    AZURE_ENVIRONMENT environment variable. Vaults (-url, -vault and vaults in the -a file) can be given by name,
+    for example name-of-keyvault becomes https://name-of-keyvault.vault.usgovcloudapi.net with -azure-cloud=usgovernment.
+
+    Secret store calls that fail with a throttling (429), server (5xx) or network error are retried (-retries) with
+    exponential backoff. Use -v=2 to log the retries.
 
     filebase, filedir, fileclean, fileext
     Versions of base, dir, clean, ext that also work on Windows.
 
     {{ .Files.Get "filename" }}
+
+    Exists, GetOr, Stat (Name, Size, Mode, ModTime, IsDir) and List (sorted names in a directory) help with
+    optional files:
+    {{ if .Files.Exists "override.yaml" }}{{ .Files.Get "override.yaml" }}{{ end }}
+    {{ .Files.GetOr (printf "env/%s.yaml" .Values.env) "" }}
+
+    Partials are files with templates that are shared by all templates (set with -partials or partials: in the -a
+    file). {{ template "name" . }} expands a partial, include does the same but returns the result so it can be piped:
+    {{ include "labels" . | indent 4 }}
+
+    Render expands a file as template with the given data, tpl does the same for a string:
+    {{ .Files.Render "snippets/probe.tpl" . | indent 8 }}
+    {{ tpl .Values.greeting . }}
 	
     {{ range $name, $content := .Files.Glob "examples/*.yaml" }}
       {{ filebase $name }}: |
-    {{ $content | indent 4 }}{{ end }}
+    {{ $content | toString | indent 4 }}{{ end }}
 	
     {{ (.Files.Glob "examples/*.yaml").AsConfig | indent 4 }}
 	
     {{ (.Files.Glob "secrets/*").AsSecrets }}
 
-Beware: file access is not sanitized.
+    Checksum hashes (sha256) the sorted names and contents of the files matching the globs to roll Deployments when
+    config changes, Glob(...).Checksum takes the algorithm (sha256, sha512, sha1 or md5):
+    checksum/config: {{ .Files.Checksum "config/**" "!**/*.bak" }}
+    checksum/config: {{ (.Files.Glob "config/**").Checksum "sha512" }}
+
+    Archive reads the files of a tar, tar.gz or zip archive (in memory), the result has Get, Glob, AsConfig etc.:
+    {{ ((.Files.Archive "bundle.tgz").Glob "conf/*.yaml").AsConfig | indent 4 }}
+
+    Glob patterns support ** (any number of directories), {a,b} alternatives and exclusions with a leading !,
+    the files are returned in sorted order. Names are slash separated and relative to the template directory,
+    keys returns them sorted:
+    {{ .Files.Glob "config/**" | keys | join "," }}
+    {{ (.Files.Glob "config/**/*.{yaml,conf}" "!**/test-*").AsConfig | indent 4 }}
+    {{ (.Files.GlobExclude "config/**" "**/*.bak").AsConfig | indent 4 }}
+
+    AsConfig and AsSecrets use the file names as keys, duplicate keys stop the expansion. KeyByPath uses the path
+    relative to the common directory of the files with / replaced by a separator, KeyBy uses a template:
+    {{ ((.Files.Glob "config/**").KeyByPath "_").AsConfig | indent 4 }}
+    {{ ((.Files.Glob "config/**").KeyBy "{{ .Dir | base }}-{{ .Base }}").AsConfig | indent 4 }}
+
+    AsConfigMap and AsSecret return complete manifests. Options are namespace, labels, annotations and hash (append a
+    content hash to the name), AsSecret also takes type and stringData (put UTF-8 files in stringData).
+    Files that are not valid UTF-8 go in binaryData of a ConfigMap:
+    {{ (.Files.Glob "config/*").AsConfigMap "app-config" (dict "namespace" "app" "labels" .Values.labels "hash" true) }}
+    {{ (.Files.Glob "tls/*").AsSecret "app-tls" (dict "type" "kubernetes.io/tls") }}
+
+    {{ .Files.GetBytes "keystore.jks" | toString | b64enc }}
+
+    {{ range .Files.Lines "hosts.txt" }}{{ . }}{{ end }}
+    Head and Grep skip blank and comment (#) lines:
+    {{ range .Files.Head "hosts.txt" 3 }}{{ . }}{{ end }}
+    {{ range .Files.Grep "allow.txt" "^10[.]" }}{{ . }}{{ end }}
+
+File access is limited to -files-root; paths (also after resolving symlinks) outside of it stop the expansion.
+Glob reads the content of a file when it's used, ranging over the names only doesn't read the files. Reading more than
+-files-max-size bytes from the files of a Glob, or from an Archive (its file or its extracted files), stops the expansion.
 
 
 Usage: tmplt [options...]
@@ -83,6 +200,8 @@ Usage: tmplt [options...]
 )
 
 func init() {
+	flag.Var(vaults, "vault",
+		`For provider=azkv; alias=url of an additional vault, can be repeated.`)
 	flag.Usage = func() {
 		_, _ = fmt.Fprintf(os.Stderr, usage, Version)
 		flag.PrintDefaults()
@@ -97,8 +216,30 @@ func main() {
 		os.Exit(1)
 	}
 
-	glog.V(2).Infof("provider=%s url=%s tmplt=%s all=%s set-file=%s", *provider, *url, *tmplt, *all, *setFile)
-	err := expand.Run(*provider, *url, *username, *passw, *domain, *tmplt, *all, *setFile, expand.OSEnvironment(), os.Stdout)
+	glog.V(2).Infof("provider=%s url=%s vault=%s tmplt=%s all=%s set-file=%s lock-file=%s frozen=%t azure-auth=%s azure-cloud=%s timeout=%v retries=%d deadline=%v files-root=%s files-max-size=%d partials=%s",
+		*provider, *url, vaults, *tmplt, *all, *setFile, *lockFile, *frozen, *azureAuth, *azureCloud, *timeout, *retries, *deadline, *filesRoot, *filesMaxSize, *partials)
+	opts := expand.Options{
+		Provider:     *provider,
+		URL:          *url,
+		Username:     *username,
+		Password:     *passw,
+		Domain:       *domain,
+		Template:     *tmplt,
+		All:          *all,
+		SetFile:      *setFile,
+		LockFile:     *lockFile,
+		Frozen:       *frozen,
+		Vaults:       vaults,
+		AzureAuth:    *azureAuth,
+		AzureCloud:   *azureCloud,
+		Timeout:      *timeout,
+		Retries:      *retries,
+		Deadline:     *deadline,
+		FilesRoot:    *filesRoot,
+		FilesMaxSize: *filesMaxSize,
+		Partials:     *partials,
+	}
+	err := expand.Run(opts, expand.OSEnvironment(), os.Stdout)
 	if err != nil {
 		glog.Exit(err)
 	}
@@ -120,14 +261,21 @@ func validate() (msg string, ok bool) {
 			return "provider=thycotic requires -url to be set.", false
 		}
 	case "azkv":
-		env := expand.OSEnvironment()
-		for _,v := range []string{"AZURE_TENANT_ID", "AZURE_CLIENT_ID", "AZURE_CLIENT_SECRET"} {
-			if _, ok := env[v]; !ok{
-				return fmt.Sprintf("provider=azkv requires environment variable %s to be set.", v), false
-			}
+		if err := (azkv.Auth{Method: *azureAuth, Env: expand.OSEnvironment()}).Validate(); err != nil {
+			return fmt.Sprintf("provider=azkv: %v.", err), false
 		}
-		if *url == "" {
-			return "provider=thycotic requires -url to be set.", false
+		if *url == "" && len(vaults) == 0 && *all == "" {
+			return "provider=azkv requires -url or -vault to be set.", false
+		}
+		cloud, err := azkv.Cloud(*azureCloud, expand.OSEnvironment())
+		if err != nil {
+			return fmt.Sprintf("-azure-cloud %v", err), false
+		}
+		if err := vaults.Expand(cloud); err != nil {
+			return fmt.Sprintf("-vault %v", err), false
+		}
+		if err := vaults.Validate(); err != nil {
+			return fmt.Sprintf("-vault %v", err), false
 		}
 	case "":
 		if (*username != "") || (*passw != "") {
@@ -136,5 +284,25 @@ func validate() (msg string, ok bool) {
 	default:
 		return "-provider should be set to 'thycotic' or 'azkv' or not be set.", false
 	}
+
+	if *timeout < 0 || *retries < 0 || *deadline < 0 || *filesMaxSize < 0 {
+		return "-timeout, -retries, -deadline and -files-max-size should not be negative.", false
+	}
+	if *filesRoot != "" {
+		if fi, err := os.Stat(*filesRoot); err != nil || !fi.IsDir() {
+			return "-files-root should be an existing directory.", false
+		}
+	}
+	if (*lockFile != "" || *frozen) && *provider != "azkv" {
+		return "-lock-file and -frozen require provider=azkv.", false
+	}
+	if *frozen {
+		if *lockFile == "" {
+			return "-frozen requires -lock-file to be set.", false
+		}
+		if _, err := os.Stat(*lockFile); err != nil {
+			return fmt.Sprintf("-frozen requires an existing -lock-file: %v", err), false
+		}
+	}
 	return "", true
 }
@@ -0,0 +1,255 @@
+// Package retry provides a http.RoundTripper that retries failed secret store requests.
+package retry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Policy configures the retries of Transport.
+type Policy struct {
+	// Retries is the maximum number of retries of a request.
+	Retries int
+	// Timeout is the maximum duration of an attempt, 0 means no timeout.
+	Timeout time.Duration
+	// Deadline when not zero is the time after which no attempts are made.
+	Deadline time.Time
+	// Backoff is the delay before the first retry, the delay doubles for each retry up to MaxBackoff.
+	// The actual delay is randomly chosen between half and the full delay.
+	Backoff, MaxBackoff time.Duration
+}
+
+// DefaultBackoff and DefaultMaxBackoff are used when Policy.Backoff or Policy.MaxBackoff are not set.
+const (
+	DefaultBackoff    = 500 * time.Millisecond
+	DefaultMaxBackoff = 10 * time.Second
+)
+
+// AzureCodes are the HTTP status codes that are retried for Azure.
+var AzureCodes = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// SOAPCodes are the HTTP status codes that are retried for SOAP services.
+// 500 is not retried because SOAP faults use it.
+var SOAPCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// Transport is a http.RoundTripper that retries requests that fail with a network error or with one of Codes.
+// Requests with a body are only retried when the body can be replayed (http.Request.GetBody is set or ReplayBody).
+type Transport struct {
+	Policy
+	// Codes are the retryable HTTP status codes.
+	Codes []int
+	// Name is used in log messages.
+	Name string
+	// ReplayBody when true reads a body that can't be replayed in memory so the request can be retried.
+	// It's meant for small bodies like token requests.
+	ReplayBody bool
+	// Base is the RoundTripper that sends the requests, nil means http.DefaultTransport.
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+// When all attempts fail with a retryable status code an error is returned (instead of a response).
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	retries := t.Retries
+	if req.Body != nil && req.GetBody == nil {
+		if t.ReplayBody {
+			var err error
+			req, err = replayable(req)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			retries = 0
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := t.try(req, attempt)
+		reason := ""
+		switch {
+		case err != nil:
+			if req.Context().Err() != nil || !Temporary(err) {
+				return nil, err
+			}
+			reason = err.Error()
+		case t.retryable(resp.StatusCode):
+			reason = resp.Status
+		default:
+			if attempt > 0 {
+				glog.V(2).Infof("%s: %s %s succeeded after %d retries", t.Name, req.Method, req.URL, attempt)
+			}
+			return resp, nil
+		}
+
+		delay := t.delay(attempt, resp)
+		if resp != nil {
+			_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+			resp.Body.Close()
+		}
+		if attempt >= retries {
+			return nil, fmt.Errorf("%s after %d attempts", reason, attempt+1)
+		}
+		if !t.Deadline.IsZero() && time.Now().Add(delay).After(t.Deadline) {
+			return nil, fmt.Errorf("%s (deadline exceeded after %d attempts)", reason, attempt+1)
+		}
+
+		glog.V(2).Infof("%s: %s %s: %s, retry %d of %d in %v",
+			t.Name, req.Method, req.URL, reason, attempt+1, retries, delay)
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// Replayable returns a copy of 'req' with its body read in memory and GetBody set.
+func replayable(req *http.Request) (*http.Request, error) {
+	b, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	r := req.WithContext(req.Context())
+	r.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(b)), nil
+	}
+	r.Body, _ = r.GetBody()
+	return r, nil
+}
+
+// Try sends one attempt of 'req' with the timeout and deadline of the policy.
+func (t *Transport) try(req *http.Request, attempt int) (*http.Response, error) {
+	ctx := req.Context()
+	var cancels []context.CancelFunc
+	if t.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.Timeout)
+		cancels = append(cancels, cancel)
+	}
+	if !t.Deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, t.Deadline)
+		cancels = append(cancels, cancel)
+	}
+	cancel := func() {
+		for _, c := range cancels {
+			c()
+		}
+	}
+
+	r := req.WithContext(ctx)
+	if attempt > 0 && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		r.Body = body
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(r)
+	if err != nil {
+		cancel()
+		if !t.Deadline.IsZero() && !time.Now().Before(t.Deadline) {
+			return nil, fmt.Errorf("deadline exceeded: %v", err)
+		}
+		return nil, err
+	}
+	// the contexts are cancelled when the body is closed.
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// Retryable returns true when 'code' is one of the retryable status codes.
+func (t *Transport) retryable(code int) bool {
+	for _, c := range t.Codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Delay returns the delay before retry 'attempt'+1.
+// A Retry-After header (in seconds) in 'resp' takes precedence.
+func (t *Transport) delay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+			return time.Duration(s) * time.Second
+		}
+	}
+	d, max := t.Backoff, t.MaxBackoff
+	if d <= 0 {
+		d = DefaultBackoff
+	}
+	if max <= 0 {
+		max = DefaultMaxBackoff
+	}
+	for i := 0; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Temporary returns true for network errors that might not occur when the request is retried, like connection
+// resets and timeouts.
+func Temporary(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	return strings.Contains(err.Error(), "connection reset")
+}
+
+// CancelBody cancels the request context when the body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel func()
+}
+
+// Close closes the body and cancels the context.
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
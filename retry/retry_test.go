@@ -0,0 +1,181 @@
+package retry_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mmlt/tool-tmplt/retry"
+	"github.com/stretchr/testify/assert"
+)
+
+// NewServer returns a server that calls 'handle' with the attempt number (starting at 1).
+func newServer(handle func(attempt int, w http.ResponseWriter, r *http.Request)) *httptest.Server {
+	var mu sync.Mutex
+	attempt := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempt++
+		a := attempt
+		mu.Unlock()
+		handle(a, w, r)
+	}))
+}
+
+// NewClient returns a client that retries with 'policy'.
+func newClient(policy retry.Policy) *http.Client {
+	policy.Backoff = time.Millisecond
+	return &http.Client{Transport: &retry.Transport{Policy: policy, Codes: retry.AzureCodes, Name: "test"}}
+}
+
+func TestTransport_Status(t *testing.T) {
+	tests := []struct {
+		it       string
+		statuses []int
+		retries  int
+		want     int
+		wantErr  string
+		attempts int
+	}{
+		{
+			it:       "should retry until success",
+			statuses: []int{503, 429, 200},
+			retries:  3,
+			want:     200,
+			attempts: 3,
+		},
+		{
+			it:       "should fail when retries are exhausted",
+			statuses: []int{429, 429, 429, 200},
+			retries:  2,
+			wantErr:  "429 Too Many Requests after 3 attempts",
+			attempts: 3,
+		},
+		{
+			it:       "should not retry other statuses",
+			statuses: []int{404, 200},
+			retries:  3,
+			want:     404,
+			attempts: 1,
+		},
+	}
+	for _, tst := range tests {
+		attempts := 0
+		srv := newServer(func(attempt int, w http.ResponseWriter, r *http.Request) {
+			attempts = attempt
+			w.WriteHeader(tst.statuses[attempt-1])
+		})
+		resp, err := newClient(retry.Policy{Retries: tst.retries}).Get(srv.URL)
+		if tst.wantErr != "" {
+			if assert.Error(t, err, tst.it) {
+				assert.True(t, strings.HasSuffix(err.Error(), tst.wantErr), "%s: got %v", tst.it, err)
+			}
+		} else if assert.NoError(t, err, tst.it) {
+			assert.Equal(t, tst.want, resp.StatusCode, tst.it)
+			resp.Body.Close()
+		}
+		assert.Equal(t, tst.attempts, attempts, tst.it)
+		srv.Close()
+	}
+}
+
+func TestTransport_Timeout(t *testing.T) {
+	srv := newServer(func(attempt int, w http.ResponseWriter, r *http.Request) {
+		if attempt == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(2 * time.Second):
+			}
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	})
+	defer srv.Close()
+
+	resp, err := newClient(retry.Policy{Retries: 1, Timeout: 100 * time.Millisecond}).Get(srv.URL)
+	if assert.NoError(t, err) {
+		b, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "ok", string(b))
+		resp.Body.Close()
+	}
+}
+
+func TestTransport_ConnectionReset(t *testing.T) {
+	srv := newServer(func(attempt int, w http.ResponseWriter, r *http.Request) {
+		if attempt == 1 {
+			// drop the connection without response.
+			conn, _, err := w.(http.Hijacker).Hijack()
+			assert.NoError(t, err)
+			conn.Close()
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	})
+	defer srv.Close()
+
+	resp, err := newClient(retry.Policy{Retries: 1}).Get(srv.URL)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 200, resp.StatusCode)
+		resp.Body.Close()
+	}
+}
+
+func TestTransport_Body(t *testing.T) {
+	var bodies []string
+	srv := newServer(func(attempt int, w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		if attempt == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	defer srv.Close()
+
+	resp, err := newClient(retry.Policy{Retries: 1}).Post(srv.URL, "text/plain", strings.NewReader("payload"))
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+	assert.Equal(t, []string{"payload", "payload"}, bodies)
+
+	// a body that can't be replayed is only retried with ReplayBody.
+	bodies = nil
+	srv2 := newServer(func(attempt int, w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		if attempt <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	defer srv2.Close()
+	body := ioutil.NopCloser(strings.NewReader("payload"))
+	_, err = newClient(retry.Policy{Retries: 1}).Post(srv2.URL, "text/plain", body)
+	assert.Error(t, err)
+	client := newClient(retry.Policy{Retries: 1})
+	client.Transport.(*retry.Transport).ReplayBody = true
+	body = ioutil.NopCloser(strings.NewReader("payload"))
+	resp, err = client.Post(srv2.URL, "text/plain", body)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+	assert.Equal(t, []string{"payload", "payload", "payload"}, bodies)
+}
+
+func TestTransport_Deadline(t *testing.T) {
+	srv := newServer(func(attempt int, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "2")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	defer srv.Close()
+
+	start := time.Now()
+	_, err := newClient(retry.Policy{Retries: 10, Deadline: time.Now().Add(time.Second)}).Get(srv.URL)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "429 Too Many Requests (deadline exceeded after 1 attempts)")
+	}
+	assert.True(t, time.Since(start) < time.Second, "should not wait past the deadline")
+}
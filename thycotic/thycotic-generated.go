@@ -15,6 +15,17 @@ import (
 //PE added
 type String string
 
+//ADDED
+// HTTPError is returned by SOAPClient.Call when the server responds with an HTTP error status without SOAP fault.
+type HTTPError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *HTTPError) Error() string {
+	return "http status " + e.Status
+}
+
 // against "unused imports"
 var _ time.Time
 var _ xml.Name
@@ -2022,9 +2033,10 @@ type SearchFolderResult struct {
 }
 
 type FileDownloadResult struct {
-	XMLName xml.Name `xml:"urn:thesecretserver.com FileDownloadResult"`
+	//REMOVED(xml: name "DownloadFileAttachmentByItemIdResult" in tag of thycotic.DownloadFileAttachmentByItemIdResponse.DownloadFileAttachmentByItemIdResult conflicts with name "FileDownloadResult" in *thycotic.FileDownloadResult.XMLName) XMLName xml.Name `xml:"urn:thesecretserver.com FileDownloadResult"`
 
-	*WebServiceResult
+	//REPLACED(expected element type <WebServiceResult> but have <DownloadFileAttachmentByItemIdResult>) *WebServiceResult
+	Errors *ArrayOfString `xml:"Errors,omitempty"`
 
 	FileAttachment []byte `xml:"FileAttachment,omitempty"`
 
@@ -2337,9 +2349,10 @@ type Group struct {
 }
 
 type SSHCredentialsResult struct {
-	XMLName xml.Name `xml:"urn:thesecretserver.com SSHCredentialsResult"`
+	//REMOVED(xml: name "GetSSHLoginCredentialsWithMachineResult" in tag of thycotic.GetSSHLoginCredentialsWithMachineResponse.GetSSHLoginCredentialsWithMachineResult conflicts with name "SSHCredentialsResult" in *thycotic.SSHCredentialsResult.XMLName) XMLName xml.Name `xml:"urn:thesecretserver.com SSHCredentialsResult"`
 
-	*WebServiceResult
+	//REPLACED(expected element type <WebServiceResult> but have <GetSSHLoginCredentialsWithMachineResult>) *WebServiceResult
+	Errors *ArrayOfString `xml:"Errors,omitempty"`
 
 	Username string `xml:"Username,omitempty"`
 
@@ -2381,7 +2394,7 @@ type UpdateUserResult struct {
 }
 
 type SecretItemHistoryResult struct {
-	XMLName xml.Name `xml:"urn:thesecretserver.com SecretItemHistoryResult"`
+	//REMOVED(xml: name "GetSecretItemHistoryByFieldNameResult" in tag of thycotic.GetSecretItemHistoryByFieldNameResponse.GetSecretItemHistoryByFieldNameResult conflicts with name "SecretItemHistoryResult" in *thycotic.SecretItemHistoryResult.XMLName) XMLName xml.Name `xml:"urn:thesecretserver.com SecretItemHistoryResult"`
 
 	Errors *ArrayOfString `xml:"Errors,omitempty"`
 
@@ -2391,7 +2404,7 @@ type SecretItemHistoryResult struct {
 }
 
 type ArrayOfSecretItemHistoryWebServiceResult struct {
-	XMLName xml.Name `xml:"urn:thesecretserver.com ArrayOfSecretItemHistoryWebServiceResult"`
+	//REMOVED(xml: name "SecretItemHistories" in tag of thycotic.SecretItemHistoryResult.SecretItemHistories conflicts with name "ArrayOfSecretItemHistoryWebServiceResult" in *thycotic.ArrayOfSecretItemHistoryWebServiceResult.XMLName) XMLName xml.Name `xml:"urn:thesecretserver.com ArrayOfSecretItemHistoryWebServiceResult"`
 
 	SecretItemHistoryWebServiceResult []*SecretItemHistoryWebServiceResult `xml:"SecretItemHistoryWebServiceResult,omitempty"`
 }
@@ -2407,7 +2420,8 @@ type SecretItemHistoryWebServiceResult struct {
 
 	SecretId int32 `xml:"SecretId,omitempty"`
 
-	Date time.Time `xml:"Date,omitempty"`
+	//REPLACED(parsing time "2017-11-01T10:00:00.123" as "2006-01-02T15:04:05Z07:00": cannot parse "" as "Z07:00") Date time.Time `xml:"Date,omitempty"`
+	Date string `xml:"Date,omitempty"`
 
 	ItemValueNew string `xml:"ItemValueNew,omitempty"`
 
@@ -4936,6 +4950,8 @@ type SOAPClient struct {
 	tlsCfg  *tls.Config
 	auth    *BasicAuth
 	headers []interface{}
+	//ADDED wrapTransport (when not nil) wraps the transport used by Call.
+	wrapTransport func(http.RoundTripper) http.RoundTripper
 }
 
 // **********
@@ -5095,6 +5111,10 @@ func (s *SOAPClient) Call(soapAction string, request, response interface{}) erro
 	}
 
 	client := &http.Client{Transport: tr}
+	//ADDED
+	if s.wrapTransport != nil {
+		client.Transport = s.wrapTransport(tr)
+	}
 	res, err := client.Do(req)
 	if err != nil {
 		return err
@@ -5107,6 +5127,10 @@ func (s *SOAPClient) Call(soapAction string, request, response interface{}) erro
 	}
 	if len(rawbody) == 0 {
 		glog.V(3).Info("empty response")
+		//ADDED
+		if res.StatusCode >= http.StatusBadRequest {
+			return &HTTPError{StatusCode: res.StatusCode, Status: res.Status}
+		}
 		return nil
 	}
 
@@ -5115,6 +5139,10 @@ func (s *SOAPClient) Call(soapAction string, request, response interface{}) erro
 	respEnvelope.Body = SOAPBody{Content: response}
 	err = xml.Unmarshal(rawbody, respEnvelope)
 	if err != nil {
+		//ADDED
+		if res.StatusCode >= http.StatusBadRequest {
+			return &HTTPError{StatusCode: res.StatusCode, Status: res.Status}
+		}
 		return err
 	}
 
@@ -6,8 +6,8 @@ import (
 	"fmt"
 )
 
-//
-func Unmarchall_GetSecretResponse(t *testing.T) {
+// TestUnmarshal_GetSecretResponse tests if a GetSecret response is decoded.
+func TestUnmarshal_GetSecretResponse(t *testing.T) {
 	rawbody := []byte(`
 <?xml version="1.0" encoding="utf-8"?>
 <soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"
@@ -83,6 +83,93 @@ func Unmarchall_GetSecretResponse(t *testing.T) {
 	respEnvelope.Body = SOAPBody{Content: response}
 	err := xml.Unmarshal(rawbody, respEnvelope)
 	if err != nil {
-		fmt.Print(err)
+		t.Fatal(err)
+	}
+	s := response.GetSecretResult.Secret
+	if s == nil || s.Id != 37027 || len(s.Items.SecretItem) != 4 {
+		t.Fatalf("unexpected result: %+v", s)
+	}
+	if got := fmt.Sprint(s.Items.SecretItem[2].FieldName, "=", s.Items.SecretItem[2].Value); got != "Password=this-is-a-secret" {
+		t.Errorf("got %s", got)
+	}
+}
+
+// TestUnmarshal_GetSSHLoginCredentialsWithMachineResponse tests if the SSH credentials (and errors) are decoded.
+func TestUnmarshal_GetSSHLoginCredentialsWithMachineResponse(t *testing.T) {
+	rawbody := []byte(`
+<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"
+               xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+    <soap:Body>
+        <GetSSHLoginCredentialsWithMachineResponse xmlns="urn:thesecretserver.com">
+            <GetSSHLoginCredentialsWithMachineResult>
+                <Errors/>
+                <Username>deploy</Username>
+                <Password>this-is-a-secret</Password>
+                <Host>host.example.com</Host>
+                <Port>22</Port>
+            </GetSSHLoginCredentialsWithMachineResult>
+        </GetSSHLoginCredentialsWithMachineResponse>
+    </soap:Body>
+</soap:Envelope>
+	`)
+	response := new(GetSSHLoginCredentialsWithMachineResponse)
+	respEnvelope := new(SOAPEnvelope)
+	respEnvelope.Body = SOAPBody{Content: response}
+	err := xml.Unmarshal(rawbody, respEnvelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := response.GetSSHLoginCredentialsWithMachineResult
+	if r == nil || r.Username != "deploy" || r.Password != "this-is-a-secret" || r.Host != "host.example.com" || r.Port != "22" {
+		t.Errorf("unexpected result: %+v", r)
+	}
+}
+
+// TestUnmarshal_GetSecretItemHistoryByFieldNameResponse tests if the item history (with zone-less dates) is decoded.
+func TestUnmarshal_GetSecretItemHistoryByFieldNameResponse(t *testing.T) {
+	rawbody := []byte(`
+<?xml version="1.0" encoding="utf-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"
+               xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema">
+    <soap:Body>
+        <GetSecretItemHistoryByFieldNameResponse xmlns="urn:thesecretserver.com">
+            <GetSecretItemHistoryByFieldNameResult>
+                <Errors/>
+                <Success>true</Success>
+                <SecretItemHistories>
+                    <SecretItemHistoryWebServiceResult>
+                        <SecretItemHistoryId>1</SecretItemHistoryId>
+                        <UserId>3</UserId>
+                        <SecretItemId>170104</SecretItemId>
+                        <SecretId>37027</SecretId>
+                        <Date>2017-11-01T10:00:00.123</Date>
+                        <ItemValueNew>old-secret</ItemValueNew>
+                        <ItemValueNew2/>
+                    </SecretItemHistoryWebServiceResult>
+                </SecretItemHistories>
+            </GetSecretItemHistoryByFieldNameResult>
+        </GetSecretItemHistoryByFieldNameResponse>
+    </soap:Body>
+</soap:Envelope>
+	`)
+	response := new(GetSecretItemHistoryByFieldNameResponse)
+	respEnvelope := new(SOAPEnvelope)
+	respEnvelope.Body = SOAPBody{Content: response}
+	err := xml.Unmarshal(rawbody, respEnvelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := response.GetSecretItemHistoryByFieldNameResult
+	if r == nil || r.SecretItemHistories == nil || len(r.SecretItemHistories.SecretItemHistoryWebServiceResult) != 1 {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+	h := r.SecretItemHistories.SecretItemHistoryWebServiceResult[0]
+	if h.ItemValueNew != "old-secret" {
+		t.Errorf("got value %q", h.ItemValueNew)
+	}
+	d, err := ParseTime(h.Date)
+	if err != nil || d.Format("2006-01-02 15:04") != "2017-11-01 10:00" {
+		t.Errorf("got date %v err %v", d, err)
 	}
 }
@@ -1,12 +1,23 @@
 package thycotic
 
 import (
+	"encoding/base64"
 	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mmlt/tool-tmplt/retry"
 )
 
-// Login
-func Login(url, username, passw, domain string) (*SSWebServiceSoap, string, error) {
+// Login authenticates with a Thycotic secret server and returns a client and token for subsequent calls.
+// Calls made with the client are retried according to 'policy'.
+func Login(url, username, passw, domain string, policy retry.Policy) (*SSWebServiceSoap, string, error) {
 	client := NewSSWebServiceSoap(fmt.Sprint(url, "/SecretServer/webservices/sswebservice.asmx"), true, nil) //TODO change true in false or make it a flag
+	client.client.wrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		return &retry.Transport{Policy: policy, Codes: retry.SOAPCodes, Name: "thycotic", Base: rt}
+	}
 
 	ar, err := client.Authenticate(
 		&Authenticate{
@@ -19,8 +30,14 @@ func Login(url, username, passw, domain string) (*SSWebServiceSoap, string, erro
 		return nil, "", err
 	}
 
+	if ar.AuthenticateResult == nil {
+		return nil, "", fmt.Errorf("unauthorized")
+	}
 	token := ar.AuthenticateResult.Token
 	if token == "" {
+		if err := errorsOf(ar.AuthenticateResult.Errors); err != nil {
+			return nil, "", fmt.Errorf("unauthorized: %v", err)
+		}
 		return nil, "", fmt.Errorf("unauthorized")
 	}
 
@@ -29,18 +46,231 @@ func Login(url, username, passw, domain string) (*SSWebServiceSoap, string, erro
 
 // Get returns a secret value from a Thycotic secret server based on secret id and item field name.
 func Get(id int32, item string, client *SSWebServiceSoap, token string) (string, error) {
-	response, err := client.GetSecret(&GetSecret{Token: token, SecretId: id})
+	secret, err := getSecret(id, client, token)
 	if err != nil {
-		return "<unknown-secret>", fmt.Errorf("no secret %d: %s", id, err)
-	}
-	if response.GetSecretResult.Secret == nil {
-		return "<unknown-secret>", fmt.Errorf("no secret %d", id)
+		return "<unknown-secret>", err
 	}
 
-	for _, si := range response.GetSecretResult.Secret.Items.SecretItem {
+	for _, si := range secret.Items.SecretItem {
 		if si.FieldName == item {
 			return si.Value, nil
 		}
 	}
 	return "<unknown-secret-field>", fmt.Errorf("secret %d has no field %s", id, item)
 }
+
+// GetSSH returns the SSH login credentials of a Thycotic secret as a map with username, password, host, port and
+// privateKey keys.
+// When machine is not empty the credentials are requested for that specific machine.
+// The privateKey is read from the "Private Key" field of the secret (empty when the secret has no such field).
+func GetSSH(id int32, machine string, client *SSWebServiceSoap, token string) (map[string]string, error) {
+	var result *SSHCredentialsResult
+	if machine == "" {
+		response, err := client.GetSSHLoginCredentials(&GetSSHLoginCredentials{Token: token, SecretId: id})
+		if err != nil {
+			return nil, fmt.Errorf("no ssh credentials %d: %s", id, err)
+		}
+		result = response.GetSSHLoginCredentialsResult
+	} else {
+		response, err := client.GetSSHLoginCredentialsWithMachine(
+			&GetSSHLoginCredentialsWithMachine{Token: token, SecretId: id, Machine: machine})
+		if err != nil {
+			return nil, fmt.Errorf("no ssh credentials %d for %s: %s", id, machine, err)
+		}
+		result = response.GetSSHLoginCredentialsWithMachineResult
+	}
+	if result == nil {
+		return nil, fmt.Errorf("no ssh credentials %d", id)
+	}
+	if err := errorsOf(result.Errors); err != nil {
+		return nil, fmt.Errorf("no ssh credentials %d: %v", id, err)
+	}
+
+	key, err := getPrivateKey(id, client, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"username":   result.Username,
+		"password":   result.Password,
+		"host":       result.Host,
+		"port":       result.Port,
+		"privateKey": key,
+	}, nil
+}
+
+// GetPrivateKey returns the value of the "Private Key" field of a secret.
+// When the field is a file attachment the attachment is downloaded.
+func getPrivateKey(id int32, client *SSWebServiceSoap, token string) (string, error) {
+	secret, err := getSecret(id, client, token)
+	if err != nil {
+		return "", err
+	}
+
+	for _, si := range secret.Items.SecretItem {
+		if si.FieldName != "Private Key" {
+			continue
+		}
+		if !si.IsFile {
+			return si.Value, nil
+		}
+		fr, err := client.DownloadFileAttachmentByItemId(
+			&DownloadFileAttachmentByItemId{Token: token, SecretId: id, SecretItemId: si.Id})
+		if err != nil {
+			return "", fmt.Errorf("no private key %d: %s", id, err)
+		}
+		r := fr.DownloadFileAttachmentByItemIdResult
+		if r == nil {
+			return "", fmt.Errorf("no private key %d", id)
+		}
+		if err := errorsOf(r.Errors); err != nil {
+			return "", fmt.Errorf("no private key %d: %v", id, err)
+		}
+		// the attachment is xsd:base64Binary
+		b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(r.FileAttachment)))
+		if err != nil {
+			return "", fmt.Errorf("private key %d: %v", id, err)
+		}
+		return string(b), nil
+	}
+	return "", nil
+}
+
+// GetAt returns the value a Thycotic secret item field had at time 'at'.
+// When the field has no history the current value is returned.
+func GetAt(id int32, item string, at time.Time, client *SSWebServiceSoap, token string) (string, error) {
+	hist, err := history(id, item, client, token)
+	if err != nil {
+		return "<unknown-secret>", err
+	}
+	if len(hist) == 0 {
+		return Get(id, item, client, token)
+	}
+	for _, h := range hist {
+		if !h.date.After(at) {
+			return h.value, nil
+		}
+	}
+	return "<unknown-secret-history>", fmt.Errorf("secret %d field %s has no value at %s", id, item, at.Format(time.RFC3339))
+}
+
+// GetVersion returns a historical value of a Thycotic secret item field.
+// Version 0 is the most recent value, 1 the value before that etc.
+// When the field has no history version 0 returns the current value.
+func GetVersion(id int32, item string, version int, client *SSWebServiceSoap, token string) (string, error) {
+	hist, err := history(id, item, client, token)
+	if err != nil {
+		return "<unknown-secret>", err
+	}
+	if len(hist) == 0 && version == 0 {
+		return Get(id, item, client, token)
+	}
+	if version < 0 || version >= len(hist) {
+		return "<unknown-secret-history>", fmt.Errorf("secret %d field %s has no version %d (%d versions available)", id, item, version, len(hist))
+	}
+	return hist[version].value, nil
+}
+
+// HistoryItem is a historical value of a secret item field.
+type historyItem struct {
+	date  time.Time
+	value string
+}
+
+// History returns the values of a secret item field, most recent first.
+func history(id int32, item string, client *SSWebServiceSoap, token string) ([]historyItem, error) {
+	// the history API identifies a field by display name.
+	secret, err := getSecret(id, client, token)
+	if err != nil {
+		return nil, err
+	}
+	displayName := ""
+	for _, si := range secret.Items.SecretItem {
+		if si.FieldName == item {
+			displayName = si.FieldDisplayName
+			break
+		}
+	}
+	if displayName == "" {
+		return nil, fmt.Errorf("secret %d has no field %s", id, item)
+	}
+
+	hr, err := client.GetSecretItemHistoryByFieldName(
+		&GetSecretItemHistoryByFieldName{Token: token, SecretId: id, FieldDisplayName: displayName})
+	if err != nil {
+		return nil, fmt.Errorf("no history for secret %d field %s: %s", id, item, err)
+	}
+	r := hr.GetSecretItemHistoryByFieldNameResult
+	if r == nil {
+		return nil, fmt.Errorf("no history for secret %d field %s", id, item)
+	}
+	if err := errorsOf(r.Errors); err != nil {
+		return nil, fmt.Errorf("no history for secret %d field %s: %v", id, item, err)
+	}
+	if r.SecretItemHistories == nil {
+		return nil, nil
+	}
+
+	var answer []historyItem
+	for _, h := range r.SecretItemHistories.SecretItemHistoryWebServiceResult {
+		d, err := ParseTime(h.Date)
+		if err != nil {
+			return nil, fmt.Errorf("history of secret %d field %s: %v", id, item, err)
+		}
+		answer = append(answer, historyItem{date: d, value: h.ItemValueNew})
+	}
+	sort.SliceStable(answer, func(i, j int) bool { return answer[i].date.After(answer[j].date) })
+
+	return answer, nil
+}
+
+// GetSecret returns the secret with 'id'.
+// The returned secret always has Items.
+func getSecret(id int32, client *SSWebServiceSoap, token string) (*Secret, error) {
+	response, err := client.GetSecret(&GetSecret{Token: token, SecretId: id})
+	if err != nil {
+		return nil, fmt.Errorf("no secret %d: %s", id, err)
+	}
+	r := response.GetSecretResult
+	if r == nil || r.Secret == nil {
+		if r != nil {
+			if err := errorsOf(r.Errors); err != nil {
+				return nil, fmt.Errorf("no secret %d: %v", id, err)
+			}
+		}
+		return nil, fmt.Errorf("no secret %d", id)
+	}
+	if r.Secret.Items == nil {
+		r.Secret.Items = &ArrayOfSecretItem{}
+	}
+	return r.Secret, nil
+}
+
+// ErrorsOf returns an error when a Thycotic result contains error messages.
+func errorsOf(errs *ArrayOfString) error {
+	if errs == nil || len(errs.String) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s", strings.Join(errs.String, "; "))
+}
+
+// TimeLayouts are the layouts accepted by ParseTime.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// ParseTime parses a (Thycotic) date time.
+// Times without zone are interpreted as UTC.
+func ParseTime(s string) (time.Time, error) {
+	for _, l := range timeLayouts {
+		t, err := time.Parse(l, s)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time %q", s)
+}
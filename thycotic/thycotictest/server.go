@@ -0,0 +1,319 @@
+// Package thycotictest provides a fake Thycotic Secret Server SOAP endpoint for testing.
+package thycotictest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mmlt/tool-tmplt/thycotic"
+)
+
+// Path is the path of the SOAP endpoint as used by thycotic.Login.
+const Path = "/SecretServer/webservices/sswebservice.asmx"
+
+// Server is a fake Secret Server that serves the SOAP calls used by the thycotic package.
+// Use URL as the url argument of thycotic.Login.
+type Server struct {
+	*httptest.Server
+
+	// Username, Password and Domain are the credentials accepted by Authenticate.
+	Username, Password, Domain string
+	// Token is the token returned by a successful Authenticate.
+	Token string
+	// Secrets are the secrets served by GetSecret and related calls.
+	Secrets map[int32]*Secret
+	// Fault when not empty is returned as SOAP fault (with HTTP status 500) for every call.
+	Fault string
+	// Status when not 0 is returned as HTTP status (with an empty body) for every call.
+	Status int
+	// Failures are HTTP statuses that are returned (with an empty body) for the next calls, one per call.
+	Failures []int
+	// RawFiles when true returns file attachments as is instead of base64 encoded.
+	RawFiles bool
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+// Secret is a Secret Server secret.
+type Secret struct {
+	Name string
+	// Items are the field values by field name.
+	Items map[string]string
+	// Files are the file attachment contents by field name.
+	Files map[string][]byte
+	// History are the historical values by field name.
+	History map[string][]History
+	// SSH are the SSH credentials as returned by GetSSHLoginCredentials(WithMachine).
+	SSH *SSH
+}
+
+// History is a historical value of a secret field.
+type History struct {
+	Date  time.Time
+	Value string
+}
+
+// SSH are SSH login credentials.
+type SSH struct {
+	Username, Password, Host, Port string
+}
+
+// NewServer starts a fake Secret Server that accepts username, password and domain.
+// The caller should call Close when finished.
+func NewServer(username, password, domain string) *Server {
+	s := &Server{
+		Username: username,
+		Password: password,
+		Domain:   domain,
+		Token:    "fake-token",
+		Secrets:  make(map[int32]*Secret),
+		calls:    make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Calls returns the number of times the SOAP action (for example "GetSecret") has been called.
+func (s *Server) Calls(action string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[action]
+}
+
+// Handle serves a SOAP request.
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	action := strings.TrimPrefix(r.Header.Get("SOAPAction"), "urn:thesecretserver.com/")
+	s.mu.Lock()
+	s.calls[action]++
+	failure := 0
+	if len(s.Failures) > 0 {
+		failure, s.Failures = s.Failures[0], s.Failures[1:]
+	}
+	s.mu.Unlock()
+
+	if failure != 0 {
+		w.WriteHeader(failure)
+		return
+	}
+
+	if r.URL.Path != Path || r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	if s.Status != 0 {
+		w.WriteHeader(s.Status)
+		return
+	}
+	if s.Fault != "" {
+		writeFault(w, "soap:Server", s.Fault)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeFault(w, "soap:Client", err.Error())
+		return
+	}
+
+	var content string
+	switch action {
+	case "Authenticate":
+		req := &thycotic.Authenticate{}
+		if !decode(w, body, req) {
+			return
+		}
+		if req.Username != s.Username || req.Password != s.Password || req.Domain != s.Domain {
+			content = `<AuthenticateResult><Errors><string>Login failed.</string></Errors></AuthenticateResult>`
+			break
+		}
+		content = fmt.Sprintf(`<AuthenticateResult><Errors/><Token>%s</Token></AuthenticateResult>`, escape(s.Token))
+	case "GetSecret":
+		req := &thycotic.GetSecret{}
+		if !decode(w, body, req) {
+			return
+		}
+		secret, errs := s.lookup(req.Token, req.SecretId)
+		if errs != "" {
+			content = `<GetSecretResult>` + errs + `</GetSecretResult>`
+			break
+		}
+		content = `<GetSecretResult><Errors/>` + secretXML(req.SecretId, secret) + `</GetSecretResult>`
+	case "GetSSHLoginCredentials", "GetSSHLoginCredentialsWithMachine":
+		req := &sshRequest{}
+		if !decode(w, body, req) {
+			return
+		}
+		content = s.sshXML(action+"Result", req.Token, req.SecretId)
+	case "DownloadFileAttachmentByItemId":
+		req := &thycotic.DownloadFileAttachmentByItemId{}
+		if !decode(w, body, req) {
+			return
+		}
+		content = s.fileXML(req.Token, req.SecretId, req.SecretItemId)
+	case "GetSecretItemHistoryByFieldName":
+		req := &thycotic.GetSecretItemHistoryByFieldName{}
+		if !decode(w, body, req) {
+			return
+		}
+		content = s.historyXML(req.Token, req.SecretId, req.FieldDisplayName)
+	default:
+		writeFault(w, "soap:Client", fmt.Sprintf("Server did not recognize the value of HTTP Header SOAPAction: %s.", action))
+		return
+	}
+
+	writeResponse(w, action+"Response", content)
+}
+
+// SshRequest is the request of both GetSSHLoginCredentials and GetSSHLoginCredentialsWithMachine.
+// (it has no XMLName so it decodes any element)
+type sshRequest struct {
+	Token    string `xml:"token"`
+	SecretId int32  `xml:"secretId"`
+	Machine  string `xml:"machine"`
+}
+
+// Lookup returns the secret with 'id' or an Errors element when the token or id is invalid.
+func (s *Server) lookup(token string, id int32) (*Secret, string) {
+	if token != s.Token {
+		return nil, `<Errors><string>Token is invalid or has expired.</string></Errors>`
+	}
+	secret, ok := s.Secrets[id]
+	if !ok {
+		return nil, fmt.Sprintf(`<Errors><string>Access Denied or Secret %d does not exist.</string></Errors>`, id)
+	}
+	return secret, ""
+}
+
+// SshXML returns the SSHCredentialsResult element named 'name'.
+func (s *Server) sshXML(name, token string, id int32) string {
+	secret, errs := s.lookup(token, id)
+	if errs == "" && secret.SSH == nil {
+		errs = fmt.Sprintf(`<Errors><string>Secret %d has no SSH credentials.</string></Errors>`, id)
+	}
+	if errs != "" {
+		return fmt.Sprintf(`<%s>%s</%s>`, name, errs, name)
+	}
+	c := secret.SSH
+	return fmt.Sprintf(`<%s><Errors/><Username>%s</Username><Password>%s</Password><Host>%s</Host><Port>%s</Port></%s>`,
+		name, escape(c.Username), escape(c.Password), escape(c.Host), escape(c.Port), name)
+}
+
+// FileXML returns the DownloadFileAttachmentByItemIdResult element.
+func (s *Server) fileXML(token string, id, itemID int32) string {
+	secret, errs := s.lookup(token, id)
+	if errs != "" {
+		return `<DownloadFileAttachmentByItemIdResult>` + errs + `</DownloadFileAttachmentByItemIdResult>`
+	}
+	for i, name := range fieldNames(secret) {
+		if itemID != itemId(id, i) {
+			continue
+		}
+		b, ok := secret.Files[name]
+		if !ok {
+			break
+		}
+		content := base64.StdEncoding.EncodeToString(b)
+		if s.RawFiles {
+			content = escape(string(b))
+		}
+		return fmt.Sprintf(`<DownloadFileAttachmentByItemIdResult><Errors/><FileAttachment>%s</FileAttachment><FileName>%s</FileName></DownloadFileAttachmentByItemIdResult>`,
+			content, escape(name))
+	}
+	return fmt.Sprintf(`<DownloadFileAttachmentByItemIdResult><Errors><string>Secret %d has no file attachment %d.</string></Errors></DownloadFileAttachmentByItemIdResult>`, id, itemID)
+}
+
+// HistoryXML returns the GetSecretItemHistoryByFieldNameResult element.
+func (s *Server) historyXML(token string, id int32, field string) string {
+	secret, errs := s.lookup(token, id)
+	if errs != "" {
+		return `<GetSecretItemHistoryByFieldNameResult>` + errs + `<Success>false</Success></GetSecretItemHistoryByFieldNameResult>`
+	}
+	var b strings.Builder
+	b.WriteString(`<GetSecretItemHistoryByFieldNameResult><Errors/><Success>true</Success><SecretItemHistories>`)
+	for i, h := range secret.History[field] {
+		fmt.Fprintf(&b, `<SecretItemHistoryWebServiceResult><SecretItemHistoryId>%d</SecretItemHistoryId><UserId>1</UserId><SecretId>%d</SecretId><Date>%s</Date><ItemValueNew>%s</ItemValueNew><ItemValueNew2/></SecretItemHistoryWebServiceResult>`,
+			i+1, id, h.Date.UTC().Format("2006-01-02T15:04:05.999"), escape(h.Value))
+	}
+	b.WriteString(`</SecretItemHistories></GetSecretItemHistoryByFieldNameResult>`)
+	return b.String()
+}
+
+// SecretXML returns the Secret element.
+func secretXML(id int32, secret *Secret) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<Secret><Name>%s</Name><Items>`, escape(secret.Name))
+	for i, name := range fieldNames(secret) {
+		_, isFile := secret.Files[name]
+		value := secret.Items[name]
+		if isFile {
+			value = "*** Not Valid For Display ***"
+		}
+		fmt.Fprintf(&b, `<SecretItem><Value>%s</Value><Id>%d</Id><FieldId>%d</FieldId><FieldName>%s</FieldName><IsFile>%t</IsFile><IsNotes>false</IsNotes><IsPassword>%t</IsPassword><FieldDisplayName>%s</FieldDisplayName></SecretItem>`,
+			escape(value), itemId(id, i), i+1, escape(name), isFile, name == "Password", escape(name))
+	}
+	fmt.Fprintf(&b, `</Items><Id>%d</Id><SecretTypeId>2</SecretTypeId><FolderId>1</FolderId><IsWebLauncher>false</IsWebLauncher><CheckOutMinutesRemaining xsi:nil="true" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"/><IsRestricted>false</IsRestricted><Active>true</Active></Secret>`, id)
+	return b.String()
+}
+
+// FieldNames returns the sorted field names of a secret.
+func fieldNames(secret *Secret) []string {
+	var names []string
+	for n := range secret.Items {
+		names = append(names, n)
+	}
+	for n := range secret.Files {
+		if _, ok := secret.Items[n]; !ok {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ItemId returns the (fake) id of the i-th item of secret 'id'.
+func itemId(id int32, i int) int32 {
+	return id*100 + int32(i)
+}
+
+// Decode decodes a SOAP request body in 'req'.
+// On failure a SOAP fault is written and false is returned.
+func decode(w http.ResponseWriter, body []byte, req interface{}) bool {
+	envelope := &thycotic.SOAPEnvelope{Body: thycotic.SOAPBody{Content: req}}
+	if err := xml.Unmarshal(body, envelope); err != nil {
+		writeFault(w, "soap:Client", err.Error())
+		return false
+	}
+	return true
+}
+
+// WriteResponse writes a SOAP response with element 'name' wrapping 'content'.
+func writeResponse(w http.ResponseWriter, name, content string) {
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	_, _ = fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body><%s xmlns="urn:thesecretserver.com">%s</%s></soap:Body></soap:Envelope>`,
+		name, content, name)
+}
+
+// WriteFault writes a SOAP fault.
+func writeFault(w http.ResponseWriter, code, msg string) {
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	_, _ = fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body><soap:Fault><faultcode>%s</faultcode><faultstring>%s</faultstring><detail/></soap:Fault></soap:Body></soap:Envelope>`,
+		code, escape(msg))
+}
+
+// Escape returns s with XML special characters escaped.
+func escape(s string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}